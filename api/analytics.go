@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultAnalyticsDays is the number of days that analytics cover when the client does not ask for a range.
+	defaultAnalyticsDays = 28
+	// maxAnalyticsDays is the largest number of days that analytics cover at once.
+	maxAnalyticsDays = 731
+)
+
+// visitCache holds the visits of the sheets in use for a while,
+// so that repeated analytics do not read the whole visit history every time.
+type visitCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]visitCacheEntry
+}
+
+type visitCacheEntry struct {
+	visits  []visit
+	expires time.Time
+}
+
+// newVisitCache returns a cache that holds visits for the given duration; if it is zero, nothing is cached.
+func newVisitCache(ttl time.Duration) *visitCache {
+	return &visitCache{ttl: ttl, entries: make(map[string]visitCacheEntry)}
+}
+
+// get returns the visits of the given store, which belongs to the sheet with the given ID,
+// from the cache or, if they are not cached or have expired, from the store.
+func (vc *visitCache) get(ctx context.Context, sid string, s Store) ([]visit, error) {
+	now := time.Now()
+	vc.Lock()
+	e, ok := vc.entries[sid]
+	vc.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.visits, nil
+	}
+	visits, err := s.Visits(ctx)
+	if err != nil || vc.ttl <= 0 {
+		return visits, err
+	}
+	vc.Lock()
+	defer vc.Unlock()
+	for id, e := range vc.entries {
+		if !now.Before(e.expires) {
+			delete(vc.entries, id)
+		}
+	}
+	vc.entries[sid] = visitCacheEntry{visits: visits, expires: now.Add(vc.ttl)}
+	return visits, nil
+}
+
+// analyticsPeriod is a length of time by which unique members are counted.
+type analyticsPeriod string
+
+const (
+	periodDay   analyticsPeriod = "day"
+	periodWeek  analyticsPeriod = "week"
+	periodMonth analyticsPeriod = "month"
+)
+
+// start returns the start in Berlin of the period that contains the given day; weeks start on Monday.
+func (p analyticsPeriod) start(day time.Time) time.Time {
+	day = midnight(day)
+	switch p {
+	case periodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case periodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// attendance is the number of visits and of the members who made them in a period.
+type attendance struct {
+	// Start is the first day of the period, of the form YYYY-MM-DD.
+	Start  string `json:"start"`
+	Visits int    `json:"visits"`
+	// Members is the number of different members who visited; visits with day passes are not counted.
+	Members int `json:"members"`
+}
+
+// analytics aggregates the visits in a range of days; all times are those of Berlin.
+type analytics struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Visits  int    `json:"visits"`
+	Members int    `json:"members"`
+	// Hourly holds the number of visits during each hour of the day.
+	Hourly [24]int `json:"hourly"`
+	// Daily holds the attendance on each day of the range, including the days without visits.
+	Daily []attendance `json:"daily"`
+	// Heatmap holds the number of visits during each hour of each weekday, starting on Monday.
+	Heatmap [7][24]int `json:"heatmap"`
+	// Period is the length of the periods by which members are counted.
+	Period analyticsPeriod `json:"period"`
+	// Periods holds the attendance in each period of the range; the first one starts on the first day of the range.
+	Periods []attendance `json:"periods"`
+}
+
+// tally counts visits and the different members who made them.
+type tally struct {
+	visits  int
+	members map[string]struct{}
+}
+
+func (t *tally) add(v visit) {
+	t.visits++
+	if v.BSID == "" {
+		return
+	}
+	if t.members == nil {
+		t.members = make(map[string]struct{})
+	}
+	t.members[v.BSID] = struct{}{}
+}
+
+// analyze aggregates the given visits between the given days in Berlin, inclusive.
+func analyze(visits []visit, from, to time.Time, p analyticsPeriod) *analytics {
+	from, to = midnight(from), midnight(to)
+	until := to.AddDate(0, 0, 1)
+	a := analytics{From: from.Format(queryDateFormat), To: to.Format(queryDateFormat), Period: p}
+	var all tally
+	daily := make(map[string]*tally)
+	periods := make(map[string]*tally)
+	for _, v := range visits {
+		t := v.Time.In(loc)
+		if t.Before(from) || !t.Before(until) {
+			continue
+		}
+		all.add(v)
+		a.Hourly[t.Hour()]++
+		a.Heatmap[(int(t.Weekday())+6)%7][t.Hour()]++
+		for _, d := range []struct {
+			tallies map[string]*tally
+			key     string
+		}{{daily, t.Format(queryDateFormat)}, {periods, p.start(t).Format(queryDateFormat)}} {
+			if d.tallies[d.key] == nil {
+				d.tallies[d.key] = &tally{}
+			}
+			d.tallies[d.key].add(v)
+		}
+	}
+	a.Visits, a.Members = all.visits, len(all.members)
+	for d := from; d.Before(until); d = d.AddDate(0, 0, 1) {
+		key := d.Format(queryDateFormat)
+		a.Daily = append(a.Daily, newAttendance(key, daily[key]))
+		if start := p.start(d); start.Equal(d) || d.Equal(from) {
+			a.Periods = append(a.Periods, newAttendance(key, periods[start.Format(queryDateFormat)]))
+		}
+	}
+	return &a
+}
+
+func newAttendance(start string, t *tally) attendance {
+	if t == nil {
+		return attendance{Start: start}
+	}
+	return attendance{Start: start, Visits: t.visits, Members: len(t.members)}
+}
+
+// analyticsHandler allows the client to get the attendance between the from and to query parameters, inclusive,
+// which default to the last four weeks. Members are counted in periods of the period query parameter:
+// day, week, or month, which is the default.
+func (a *API) analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := queryDate(r, "from")
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	to, err := queryDate(r, "to")
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if to.IsZero() {
+		to = midnight(time.Now())
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-defaultAnalyticsDays)
+	}
+	if to.Before(from) {
+		writeJSONError(errors.New("the range ends before it starts"), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if days(from, to) >= maxAnalyticsDays {
+		writeJSONError(fmt.Errorf("the range is too long; it may cover at most %d days", maxAnalyticsDays), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	p := periodMonth
+	switch v := analyticsPeriod(r.URL.Query().Get("period")); v {
+	case "":
+	case periodDay, periodWeek, periodMonth:
+		p = v
+	default:
+		writeJSONError(fmt.Errorf("%q is not a valid period; expected one of day, week, or month", v), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	sid, err := sheetFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	visits, err := a.visits.get(r.Context(), sid, s)
+	if err != nil {
+		log.Errorf("failed to list visits: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(analyze(visits, from, to, p)).ServeHTTP(w, r)
+}
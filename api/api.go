@@ -37,11 +37,20 @@ const (
 	sheetsQuery     = "mimeType = 'application/vnd.google-apps.spreadsheet'"
 	directoryQuery  = "mimeType = 'application/vnd.google-apps.folder' and trashed = false"
 	directoryName   = "user_photos"
-	userRange       = "A:K"
-	userUpdateRange = "A%d:K%d"
-	debtRange       = "DEBT!A:A"
-	visitRange      = "VISIT!A:B"
+	userRange       = "A:Z"
+	headerRange     = "A1:Z1"
+	userUpdateRange = "A%d:Z%d"
+	archiveRange    = "ARCHIVE!A:Z"
+	cardRange       = "CARD!A:D"
+	debtRange       = "DEBT!A:E"
+	debtRowRange    = "DEBT!A%d:E%d"
+	freezeRange     = "FREEZE!A:C"
+	freezeRowRange  = "FREEZE!A%d:C%d"
+	planRange       = "PLAN!A:C"
+	passRange       = "PASS!A:F"
+	visitRange      = "VISIT!A:C"
 	registerTimeout = 5 * time.Second
+	localSheetID    = "local"
 )
 
 var (
@@ -79,17 +88,63 @@ func New(config *Config, reg prometheus.Registerer, gat prometheus.Gatherer) *AP
 		handleRFID: nil,
 		hub:        websocket.NewHub(done),
 		mux:        r,
+		occupancy:  newOccupancy(config.Capacity, config.CheckOutWindow, config.DuplicateWindow, config.ExitFile == nil),
+		policy:     mustPolicy(config.Rules, config.GraceDays, config.SoonDays),
 		rfid:       rfid.New(f, done),
+		scans:      newScanTracker(config.DuplicateWindow, tapDebounce),
 		sheets:     make(map[string]string),
+		taps:       newScanTracker(tapDebounce, 0),
+		visits:     newVisitCache(config.AnalyticsCache),
+		checkInsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "berlin_strength_check_ins_total",
+				Help: "The number of scans of members and day passes by access decision and by location.",
+			},
+			[]string{"decision", "location"},
+		),
+		members: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "berlin_strength_members",
+				Help: "The number of members by sheet and by state of their membership; members may be in several states.",
+			},
+			[]string{"sheet", "status"},
+		),
+		membersExpiring: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "berlin_strength_members_expiring",
+				Help: "The number of active memberships by sheet that expire within the given number of days.",
+			},
+			[]string{"sheet", "days"},
+		),
+		rfidScanReasonsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "berlin_strength_rfid_scan_reasons_total",
+				Help: "The number of reasons given for the access decisions of RFID scans by decision and by reason; scans with several reasons are counted once for each reason.",
+			},
+			[]string{"decision", "reason"},
+		),
 		rfidScansTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "berlin_strength_rfid_scans_total",
-				Help: "The number of RFID scans.",
+				Help: "The number of RFID scans by result and by access decision.",
 			},
-			[]string{"result"},
+			[]string{"result", "decision"},
 		),
+		visitQueueDepth: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "berlin_strength_visit_queue_depth",
+				Help: "The number of visits waiting to be recorded.",
+			},
+		),
+	}
+	if config.ExitFile != nil {
+		a.exitRFID = rfid.New(config.ExitFile, done)
 	}
-	reg.MustRegister(a.rfidScansTotal)
+	a.queue = newVisitQueue(config.Journal, a.visitQueueDepth)
+	if config.RosterRefresh > 0 {
+		a.rosters = newRosterCache()
+	}
+	reg.MustRegister(a.checkInsTotal, a.members, a.membersExpiring, a.rfidScanReasonsTotal, a.rfidScansTotal, a.visitQueueDepth)
 
 	// state param cookies require HTTPS by default; disable for localhost development
 	stateConfig := gologin.DefaultCookieConfig
@@ -108,17 +163,47 @@ func New(config *Config, reg prometheus.Registerer, gat prometheus.Gatherer) *AP
 	r.Handle("/login", ins.newHandler("login", google.StateHandler(stateConfig, loginHandler(oauth2Config))))
 	r.HandleFunc("/logout", ins.newHandler("logout", http.HandlerFunc(a.logoutHandler)))
 	r.Handle("/callback", ins.newHandler("callback", google.StateHandler(stateConfig, google.CallbackHandler(oauth2Config, a.issueSession(oauth2Config), nil))))
+	r.Handle("/api/occupancy", ins.newHandler("api-occupancy", http.HandlerFunc(a.occupancyHandler))).Methods("GET")
 	r.Handle("/api/ws", ins.newHandler("api-ws", a.requireLogin(a.websocketHandler(a.hub))))
 	r.Handle("/api/scan", ins.newHandler("api-scan", a.requireLogin(http.HandlerFunc(a.scanHandler)))).Methods("GET")
+	r.Handle("/api/users", ins.newHandler("api-search-users", a.requireLogin(http.HandlerFunc(a.searchUsersHandler)))).Methods("GET").Queries("q", "{q}")
+	r.Handle("/api/users", ins.newHandler("api-list-users", a.requireLogin(http.HandlerFunc(a.listUsersHandler)))).Methods("GET")
 	r.Handle("/api/user", ins.newHandler("api-create-user", a.requireLogin(http.HandlerFunc(a.createUserHandler)))).Methods("POST")
 	r.Handle("/api/user/{id}", ins.newHandler("api-get-user", a.requireLogin(http.HandlerFunc(a.getUserHandler)))).Methods("GET")
 	r.Handle("/api/user/{id}", ins.newHandler("api-update-user", a.requireLogin(http.HandlerFunc(a.updateUserHandler)))).Methods("PUT")
+	r.Handle("/api/user/{id}", ins.newHandler("api-delete-user", a.requireLogin(http.HandlerFunc(a.deleteUserHandler)))).Methods("DELETE")
+	r.Handle("/api/user/{id}/archive", ins.newHandler("api-archive-user", a.requireLogin(http.HandlerFunc(a.archiveUserHandler)))).Methods("POST")
+	r.Handle("/api/import/sheet/{id}", ins.newHandler("api-import-sheet", a.requireLogin(http.HandlerFunc(a.importSheetHandler)))).Methods("POST")
+	r.Handle("/api/import/csv", ins.newHandler("api-import-csv", a.requireLogin(http.HandlerFunc(a.importCSVHandler)))).Methods("POST")
+	r.Handle("/api/analytics", ins.newHandler("api-analytics", a.requireLogin(http.HandlerFunc(a.analyticsHandler)))).Methods("GET")
+	r.Handle("/api/export/members", ins.newHandler("api-export-members", a.requireLogin(a.exportHandler("Members", memberHeader, exportedMembers)))).Methods("GET")
+	r.Handle("/api/export/visits", ins.newHandler("api-export-visits", a.requireLogin(a.exportHandler("Visits", visitHeader, exportedVisits)))).Methods("GET")
+	r.Handle("/api/user/{id}/debt", ins.newHandler("api-add-debt", a.requireLogin(http.HandlerFunc(a.addDebtHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/debt/{debt}/settle", ins.newHandler("api-settle-debt", a.requireLogin(http.HandlerFunc(a.settleDebtHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/freeze", ins.newHandler("api-get-freezes", a.requireLogin(http.HandlerFunc(a.getFreezesHandler)))).Methods("GET")
+	r.Handle("/api/user/{id}/freeze", ins.newHandler("api-add-freeze", a.requireLogin(http.HandlerFunc(a.addFreezeHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/freeze/{freeze}/end", ins.newHandler("api-end-freeze", a.requireLogin(http.HandlerFunc(a.endFreezeHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/cards", ins.newHandler("api-get-cards", a.requireLogin(http.HandlerFunc(a.getCardsHandler)))).Methods("GET")
+	r.Handle("/api/user/{id}/cards", ins.newHandler("api-add-card", a.requireLogin(http.HandlerFunc(a.addCardHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/cards/{rfid}/lost", ins.newHandler("api-lose-card", a.requireLogin(a.retireCardHandler(cardLost)))).Methods("POST")
+	r.Handle("/api/user/{id}/cards/{rfid}/reassign", ins.newHandler("api-reassign-card", a.requireLogin(http.HandlerFunc(a.reassignCardHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/cards/{rfid}/retire", ins.newHandler("api-retire-card", a.requireLogin(a.retireCardHandler(cardRetired)))).Methods("POST")
+	r.Handle("/api/user/{id}/renew", ins.newHandler("api-renew-user", a.requireLogin(http.HandlerFunc(a.renewUserHandler)))).Methods("POST")
+	r.Handle("/api/user/{id}/visits", ins.newHandler("api-get-visits", a.requireLogin(http.HandlerFunc(a.getVisitsHandler)))).Methods("GET")
+	r.Handle("/api/passes", ins.newHandler("api-get-passes", a.requireLogin(http.HandlerFunc(a.getPassesHandler)))).Methods("GET")
+	r.Handle("/api/passes", ins.newHandler("api-create-pass", a.requireLogin(http.HandlerFunc(a.createPassHandler)))).Methods("POST")
+	r.Handle("/api/plans", ins.newHandler("api-get-plans", a.requireLogin(http.HandlerFunc(a.getPlansHandler)))).Methods("GET")
+	r.Handle("/api/plans", ins.newHandler("api-create-plan", a.requireLogin(http.HandlerFunc(a.createPlanHandler)))).Methods("POST")
 	r.Handle("/api/sheet/{id}", ins.newHandler("api-sheet", a.requireLogin(http.HandlerFunc(a.sheetHandler)))).Methods("POST")
 	r.Handle("/api/upload", ins.newHandler("api-upload", a.requireLogin(http.HandlerFunc(a.uploadHandler)))).Methods("POST")
 	r.PathPrefix("/static/").Handler(ins.newHandler("static", http.StripPrefix("/static/", http.FileServer(statikFS))))
 	r.PathPrefix("/photo/").Handler(ins.newHandler("photo", a.requireLogin(http.StripPrefix("/photo/", http.HandlerFunc(a.photoHandler)))))
 	a.handleRFID = a.broadcastUser
 	go a.watchRFID()
+	go a.watchExitRFID()
+	go a.replayVisits()
+	go a.refreshRosters()
+	go a.watchMemberMetrics()
 	return a
 }
 
@@ -158,7 +243,9 @@ func (a *API) issueSession(config *oauth2.Config) http.Handler {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		a.sessions.Lock()
 		a.clients[googleUser.Email] = *client
+		a.sessions.Unlock()
 		s := sessionStore.New(sessionName)
 		s.Values[sessionIDKey] = googleUser.Email
 		s.Save(w)
@@ -168,132 +255,205 @@ func (a *API) issueSession(config *oauth2.Config) http.Handler {
 }
 
 func (a *API) broadcastUser(scanID string) {
-	for id, sid := range a.sheets {
+	for id, sid := range a.sessionSheets() {
 		go func(id, sid string) {
-			if c, ok := a.clients[id]; ok {
-				var u *user
-				var err error
-				var j []byte
-				defer func() {
-					if err != nil {
-						a.rfidScansTotal.WithLabelValues("error").Inc()
-						return
-					}
-					a.rfidScansTotal.WithLabelValues("success").Inc()
-				}()
-				a.hub.Send([]byte(`{"scanning":true}`), id)
-				u, _, _, err = findUser(sid, scanID, rfidColumn, c)
+			var e scanEvent
+			var err error
+			var j []byte
+			defer func() {
 				if err != nil {
-					a.hub.Send(errorToJSON(err), id)
-					log.Error(err)
+					a.rfidScansTotal.WithLabelValues("error", "").Inc()
 					return
 				}
-				j, err = json.Marshal(u)
-				if err != nil {
-					a.hub.Send(errorToJSON(err), id)
-					log.Errorf("failed to marshal user to JSON: %v", err)
-					return
+				if !e.CheckOut {
+					a.checkInsTotal.WithLabelValues(string(e.Decision), a.config.Location).Inc()
 				}
-				err = recordVisit(sid, u.BSID, c)
-				if err != nil {
-					log.Errorf("failed to record visit: %v", err)
+				a.rfidScansTotal.WithLabelValues("success", string(e.Decision)).Inc()
+				for _, r := range e.Reasons {
+					a.rfidScanReasonsTotal.WithLabelValues(string(e.Decision), string(r)).Inc()
 				}
-				a.hub.Send(j, id)
+			}()
+			a.hub.Send([]byte(`{"scanning":true}`), id)
+			e, err = a.scan(a.store(id, sid), id, sid, scanID)
+			if err != nil {
+				a.hub.Send(errorToJSON(err), id)
+				log.Error(err)
+				return
 			}
+			j, err = json.Marshal(e)
+			if err != nil {
+				a.hub.Send(errorToJSON(err), id)
+				log.Errorf("failed to marshal user to JSON: %v", err)
+				return
+			}
+			a.hub.Send(j, id)
 		}(id, sid)
 	}
 }
 
-// ensureDirectory tries to find the default photo directory and creates one if it does not already exist.
-func ensureDirectory(ctx context.Context, c client) (*drive.File, error) {
-	dirs, err := c.drive.Files.List().Context(ctx).Q(directoryQuery).Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list directories: %v", err)
+// scan resolves the given card to a member or, failing that, to a day pass and decides whether the holder may enter.
+// If so, their visit is recorded. Lost and retired cards are refused, and so is everyone when the gym is full.
+// Holders who tap their card while inside are checked out instead.
+func (a *API) scan(s Store, id, sid, scanID string) (scanEvent, error) {
+	now := time.Now()
+	// The scan is handled once for every session, but a tap records at most one visit in each sheet.
+	key := strings.Join([]string{sid, strings.ToLower(scanID)}, "/")
+	duplicate := a.scans.seen(key, now)
+	repeat := a.taps.seen(key, now)
+	u, c, err := findCardholder(context.Background(), s, scanID)
+	if err == nil && u == nil {
+		// A lost or retired card may have been bound to a day pass since.
+		if p, perr := findPass(context.Background(), s, scanID); perr != nil || p.From.Before(c.Changed) {
+			e := blockedCardEvent(context.Background(), s, c, a.policy)
+			log.Warn(e.Error)
+			return e, nil
+		}
+		err = &notFoundError{"user", scanID}
 	}
-	for _, dir := range dirs.Files {
-		if dir.Name == directoryName {
-			return dir, nil
+	if err == nil {
+		e := newScanEvent(u, a.policy, duplicate, now)
+		e.Card = c
+		if a.admit(&e, scanID, now) && !repeat {
+			e.user = a.recordVisit(s, id, sid, u)
 		}
+		return e, nil
 	}
-	return c.drive.Files.Create(&drive.File{Name: directoryName, MimeType: "application/vnd.google-apps.folder"}).Context(ctx).Fields(googleapi.Field("id")).Do()
+	if _, ok := err.(*notFoundError); !ok {
+		return scanEvent{}, err
+	}
+	p, perr := findPass(context.Background(), s, scanID)
+	if perr == nil {
+		e := passScanEvent(p, a.policy, duplicate, now)
+		if a.admit(&e, scanID, now) && !repeat {
+			a.queueVisit(s, id, sid, visit{Pass: p.ID, Time: now})
+		}
+		return e, nil
+	}
+	if _, ok := perr.(*notFoundError); !ok {
+		return scanEvent{}, perr
+	}
+	log.Warn(err)
+	e := scanEvent{Error: err.Error()}
+	e.Decision, e.Reasons = a.policy.decide([]reason{reasonUnknownCard})
+	return e, nil
 }
 
-// userRangeToUser converts a *sheets.ValueRange representing a user to a user struct.
-func userRangeToUser(vr *sheets.ValueRange, scanID string, scanIDColumn int) (*user, int, []interface{}, error) {
-	var i int
-	var row []interface{}
-	for i = range vr.Values {
-		if len(vr.Values[i]) > scanIDColumn {
-			if id, ok := vr.Values[i][scanIDColumn].(string); ok && strings.ToLower(id) == strings.ToLower(scanID) {
-				row = vr.Values[i]
-				break
-			}
+// recordVisit records a visit by the given user now and, if the user has a punch card, uses up one of their credits.
+// It returns the user with the credits that are left. Visits that fail to be recorded are queued to be retried later.
+func (a *API) recordVisit(s Store, id, sid string, u *user) *user {
+	if u.Credits != nil {
+		var err error
+		if u, err = a.useCredit(context.Background(), s, u); err != nil {
+			log.Errorf("failed to use up a visit credit of user %q: %v", u.BSID, err)
 		}
 	}
-	// The Sheets API is not 0-index.
-	i++
-	if row == nil {
-		return nil, i, nil, &notFoundError{"user", scanID}
-	}
-	u, err := rowToUser(row)
+	a.queueVisit(s, id, sid, visit{BSID: u.BSID, Time: time.Now()})
+	return u
+}
+
+// useCredit uses up one visit credit of the given user and returns a copy of the user with the credits that are left.
+// The credits are read from the store again while the lock is held, so that concurrent taps cannot use up the same credit;
+// the given user, which may be shared by the roster cache, is never modified.
+func (a *API) useCredit(ctx context.Context, s Store, u *user) (*user, error) {
+	a.credits.Lock()
+	defer a.credits.Unlock()
+	current, err := s.UserByBSID(ctx, u.BSID)
 	if err != nil {
-		return nil, i, nil, fmt.Errorf("failed to parse user: %v", err)
+		return u, err
+	}
+	// Members without credits may still be let in by the no_credits rule;
+	// their credits stay at zero rather than going negative.
+	if current.Credits == nil || *current.Credits <= 0 {
+		return u, nil
+	}
+	c := *current.Credits - 1
+	if err := s.UpdateUser(ctx, u.BSID, &user{Credits: &c}); err != nil {
+		return u, err
 	}
-	return u, i, row, nil
+	charged := *u
+	charged.Credits = &c
+	return &charged, nil
 }
 
-// debtRangeToDebt converts a *sheets.ValueRange representing debts to a boolean identifying if
-// the given user has debt.
-func debtRangeToDebt(vr *sheets.ValueRange, id string) bool {
-	for i := range vr.Values {
-		if len(vr.Values[i]) == 1 {
-			if bsID, ok := vr.Values[i][0].(string); ok && strings.ToLower(bsID) == strings.ToLower(id) {
-				return true
-			}
-		}
+// queueVisit records the given visit or, if that fails, queues it to be retried later.
+func (a *API) queueVisit(s Store, id, sid string, v visit) {
+	if err := s.RecordVisit(context.Background(), &v); err != nil {
+		log.Errorf("failed to record visit; queueing it for later: %v", err)
+		a.queue.add(queuedVisit{Email: id, Sheet: sid, Visit: v})
 	}
-	return false
 }
 
-// findUser will look for a user in the specified sheet by either BSID or RFID, and return a pointer to the user, the row of the user in the spreadsheet, and any error.
-func findUser(sid, scanID string, scanIDColumn int, c client) (*user, int, []interface{}, error) {
-	var dvr, uvr *sheets.ValueRange
-	var err error
-	if scanIDColumn == bsIDColumn {
-		res, err := c.sheets.Spreadsheets.Values.BatchGet(sid).Ranges(userRange, debtRange).MajorDimension("ROWS").Do()
-		if err != nil {
-			return nil, 0, nil, fmt.Errorf("failed to batch get spreadsheet data: %v", err)
-		}
-		uvr = res.ValueRanges[0]
-		dvr = res.ValueRanges[1]
-	} else {
-		uvr, err = c.sheets.Spreadsheets.Values.Get(sid, userRange).MajorDimension("ROWS").Do()
-		if err != nil {
-			return nil, 0, nil, fmt.Errorf("failed to get spreadsheet data: %v", err)
-		}
-		dvr, err = c.sheets.Spreadsheets.Values.Get(sid, debtRange).MajorDimension("ROWS").Do()
-		if err != nil {
-			return nil, 0, nil, fmt.Errorf("failed to get spreadsheet debt data: %v", err)
+// store returns the Store to use for the given client and sheet.
+func (a *API) store(id, sid string) Store {
+	if a.config.Store != nil {
+		return a.config.Store
+	}
+	c, _ := a.client(id)
+	return newSheetsStore(c, sid, a.config.Headers, a.rosters)
+}
+
+// client returns the Google API client of the given user, if they are logged in.
+func (a *API) client(id string) (client, bool) {
+	a.sessions.RLock()
+	defer a.sessions.RUnlock()
+	c, ok := a.clients[id]
+	return c, ok
+}
+
+// sessionSheets returns a snapshot of the sheets that the logged in users have selected, keyed by user.
+func (a *API) sessionSheets() map[string]string {
+	a.sessions.RLock()
+	defer a.sessions.RUnlock()
+	sheets := make(map[string]string, len(a.sheets))
+	for id, sid := range a.sheets {
+		if _, ok := a.clients[id]; ok {
+			sheets[id] = sid
 		}
 	}
-	u, i, row, err := userRangeToUser(uvr, scanID, scanIDColumn)
+	return sheets
+}
+
+// setSheet remembers the sheet that the given user has selected.
+func (a *API) setSheet(id, sid string) {
+	a.sessions.Lock()
+	defer a.sessions.Unlock()
+	a.sheets[id] = sid
+}
+
+// storeFromSession returns the Store to use for the session of the given request.
+func (a *API) storeFromSession(r *http.Request) (Store, error) {
+	sid, err := sheetFromSession(r)
+	if err != nil {
+		return nil, err
+	}
+	id, err := idFromSession(r)
 	if err != nil {
-		return nil, i, nil, err
+		return nil, err
 	}
-	u.Debt = debtRangeToDebt(dvr, u.BSID)
-	log.Infof("found email %q for %q", u.Email, scanID)
-	return u, i, row, nil
+	return a.store(id, sid), nil
 }
 
-func recordVisit(sid, id string, c client) error {
-	vr := &sheets.ValueRange{
-		MajorDimension: "ROWS",
-		Values: [][]interface{}{
-			{id, time.Now().Format(time.RFC3339)},
-		},
+// findDirectory tries to find the default photo directory; it returns nil if there is none.
+func findDirectory(ctx context.Context, c client) (*drive.File, error) {
+	dirs, err := c.drive.Files.List().Context(ctx).Q(directoryQuery).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directories: %v", err)
 	}
-	_, err := c.sheets.Spreadsheets.Values.Append(sid, visitRange, vr).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Do()
-	return err
+	for _, dir := range dirs.Files {
+		if dir.Name == directoryName {
+			return dir, nil
+		}
+	}
+	return nil, nil
+}
+
+// ensureDirectory tries to find the default photo directory and creates one if it does not already exist.
+func ensureDirectory(ctx context.Context, c client) (*drive.File, error) {
+	dir, err := findDirectory(ctx, c)
+	if err != nil || dir != nil {
+		return dir, err
+	}
+	return c.drive.Files.Create(&drive.File{Name: directoryName, MimeType: "application/vnd.google-apps.folder"}).Context(ctx).Fields(googleapi.Field("id")).Do()
 }
 
 // loginHandler redirects a user to the OAuth login URL to get a token.
@@ -328,8 +488,10 @@ func (a *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
+	a.sessions.Lock()
 	delete(a.clients, id)
 	delete(a.sheets, id)
+	a.sessions.Unlock()
 	sessionStore.Destroy(w, sessionName)
 	if r.Method == "POST" {
 		w.WriteHeader(http.StatusOK)
@@ -341,17 +503,12 @@ func (a *API) logoutHandler(w http.ResponseWriter, r *http.Request) {
 // getUserHandler allows the client to fetch a user from the sheet.
 func (a *API) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	bsID := mux.Vars(r)["id"]
-	sid, err := sheetFromSession(r)
-	if err != nil {
-		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
-		return
-	}
-	id, err := idFromSession(r)
+	s, err := a.storeFromSession(r)
 	if err != nil {
 		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
 		return
 	}
-	u, _, _, err := findUser(sid, bsID, bsIDColumn, a.clients[id])
+	u, err := findUser(r.Context(), s, bsID, false)
 	if err != nil {
 		if _, ok := err.(*notFoundError); ok {
 			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
@@ -366,12 +523,7 @@ func (a *API) getUserHandler(w http.ResponseWriter, r *http.Request) {
 // updateUserHandler allows the client to update a user in the sheet.
 func (a *API) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	bsID := mux.Vars(r)["id"]
-	sid, err := sheetFromSession(r)
-	if err != nil {
-		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
-		return
-	}
-	id, err := idFromSession(r)
+	s, err := a.storeFromSession(r)
 	if err != nil {
 		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
 		return
@@ -384,8 +536,7 @@ func (a *API) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	row := userToRow(&u)
-	_, n, existingRow, err := findUser(sid, bsID, bsIDColumn, a.clients[id])
+	old, err := s.UserByBSID(r.Context(), bsID)
 	if err != nil {
 		if _, ok := err.(*notFoundError); ok {
 			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
@@ -394,36 +545,43 @@ func (a *API) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
 		return
 	}
-	for i := range existingRow {
-		if i >= len(row) {
-			break
-		}
-		if v, ok := row[i].(string); ok && v != "" {
-			continue
+	if err := checkUnique(r.Context(), s, &u, bsID); err != nil {
+		if _, ok := err.(*conflictError); ok {
+			writeJSONError(err, http.StatusConflict).ServeHTTP(w, r)
+			return
 		}
-		row[i] = existingRow[i]
-	}
-	vr := &sheets.ValueRange{
-		MajorDimension: "ROWS",
-		Values:         [][]interface{}{row},
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
 	}
-	_, err = a.clients[id].sheets.Spreadsheets.Values.Update(sid, fmt.Sprintf(userUpdateRange, n, n), vr).ValueInputOption("RAW").Context(r.Context()).Do()
+	err = s.UpdateUser(r.Context(), bsID, &u)
 	if err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
 		log.Error(err)
 		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
 		return
 	}
+	if u.ID != "" && !strings.EqualFold(u.ID, old.ID) {
+		now := midnight(time.Now())
+		// Keep the replaced card in the history so that it is not simply forgotten.
+		if old.ID != "" {
+			if err := s.AddCard(r.Context(), &card{BSID: old.BSID, RFID: old.ID, Status: cardRetired, Changed: now}); err != nil {
+				log.Errorf("failed to retire the replaced card of user %q: %v", old.BSID, err)
+			}
+		}
+		// A recycled card must name its new owner in the history, or it will still be refused.
+		if err := claimCard(r.Context(), s, u.ID, old.BSID, now); err != nil {
+			log.Errorf("failed to add card to the history of user %q: %v", old.BSID, err)
+		}
+	}
 	writeJSON(u).ServeHTTP(w, r)
 }
 
 // createUserHandler allows the client to add a new row to the sheet.
 func (a *API) createUserHandler(w http.ResponseWriter, r *http.Request) {
-	sid, err := sheetFromSession(r)
-	if err != nil {
-		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
-		return
-	}
-	id, err := idFromSession(r)
+	s, err := a.storeFromSession(r)
 	if err != nil {
 		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
 		return
@@ -436,20 +594,71 @@ func (a *API) createUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	row := userToRow(&u)
-	vr := &sheets.ValueRange{
-		MajorDimension: "ROWS",
-		Values:         [][]interface{}{row},
+	if err := checkUnique(r.Context(), s, &u, ""); err != nil {
+		if _, ok := err.(*conflictError); ok {
+			writeJSONError(err, http.StatusConflict).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
 	}
-	_, err = a.clients[id].sheets.Spreadsheets.Values.Append(sid, userRange, vr).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(r.Context()).Do()
+	err = s.CreateUser(r.Context(), &u)
 	if err != nil {
 		log.Error(err)
 		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
 		return
 	}
+	if u.ID != "" {
+		// A recycled card must name its new owner in the history, or it will still be refused.
+		if err := claimCard(r.Context(), s, u.ID, u.BSID, midnight(time.Now())); err != nil {
+			log.Errorf("failed to add card to the history of user %q: %v", u.BSID, err)
+		}
+	}
 	writeJSON(u).ServeHTTP(w, r)
 }
 
+// importSheetHandler copies the members, archived members, cards, debts, freezes, passes, plans, and visits of a Google Sheet
+// into the configured store.
+func (a *API) importSheetHandler(w http.ResponseWriter, r *http.Request) {
+	sid := mux.Vars(r)["id"]
+	id, err := idFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	l, ok := a.config.Store.(loader)
+	if !ok {
+		writeJSONError(errors.New("the configured store cannot import sheets"), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	c, _ := a.client(id)
+	snap, err := newSheetsStore(c, sid, a.config.Headers, nil).snapshot(r.Context())
+	if err != nil {
+		log.Error(err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if err := l.load(r.Context(), snap); err != nil {
+		if _, ok := err.(*notEmptyError); ok {
+			writeJSONError(err, http.StatusConflict).ServeHTTP(w, r)
+			return
+		}
+		log.Errorf("failed to import sheet: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(struct {
+		Archived int `json:"archived"`
+		Cards    int `json:"cards"`
+		Debts    int `json:"debts"`
+		Freezes  int `json:"freezes"`
+		Passes   int `json:"passes"`
+		Plans    int `json:"plans"`
+		Users    int `json:"users"`
+		Visits   int `json:"visits"`
+	}{len(snap.Archived), len(snap.Cards), len(snap.Debts), len(snap.Freezes), len(snap.Passes), len(snap.Plans), len(snap.Users), len(snap.Visits)}).ServeHTTP(w, r)
+}
+
 // scanHandler grabs a single ID from the RFID scanner.
 func (a *API) scanHandler(w http.ResponseWriter, r *http.Request) {
 	sid, err := sheetFromSession(r)
@@ -492,19 +701,27 @@ func (a *API) scanOnce() (string, error) {
 // sheetHandler allows a client to specify a sheet ID.
 func (a *API) sheetHandler(w http.ResponseWriter, r *http.Request) {
 	sid := mux.Vars(r)["id"]
-	s, err := sessionStore.Get(r, sessionName)
-	if err != nil {
+	if err := a.selectSheet(w, r, sid); err != nil {
 		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
 		return
 	}
-	s.Values[sessionSheetKey] = sid
-	s.Save(w)
-	a.sheets[s.Values[sessionIDKey].(string)] = sid
 	writeJSON(struct {
 		SheetID string `json:"sheetID"`
 	}{sid}).ServeHTTP(w, r)
 }
 
+// selectSheet stores the given sheet ID in the session of the given request.
+func (a *API) selectSheet(w http.ResponseWriter, r *http.Request, sid string) error {
+	s, err := sessionStore.Get(r, sessionName)
+	if err != nil {
+		return err
+	}
+	s.Values[sessionSheetKey] = sid
+	s.Save(w)
+	a.setSheet(s.Values[sessionIDKey].(string), sid)
+	return nil
+}
+
 // websocketHandler handles websocket connections and ensures clients are
 // registered to the correct topics.
 func (a *API) websocketHandler(next http.Handler) http.Handler {
@@ -528,7 +745,8 @@ func (a *API) photoHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.FileServer(drivefs.New(a.clients[id].drive)).ServeHTTP(w, r)
+	c, _ := a.client(id)
+	http.FileServer(drivefs.New(c.drive)).ServeHTTP(w, r)
 }
 
 func (a *API) uploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -548,13 +766,14 @@ func (a *API) uploadHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(errors.New("bsID is required"), http.StatusBadRequest).ServeHTTP(w, r)
 		return
 	}
-	dir, err := ensureDirectory(r.Context(), a.clients[id])
+	c, _ := a.client(id)
+	dir, err := ensureDirectory(r.Context(), c)
 	if err != nil {
 		log.Errorf("failed to ensure photo directory exists: %v", err)
 		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
 		return
 	}
-	file, err := a.clients[id].drive.Files.Create(&drive.File{Name: bsID, Parents: []string{dir.Id}}).Context(r.Context()).Fields(googleapi.Field("id")).Media(f).Do()
+	file, err := c.drive.Files.Create(&drive.File{Name: bsID, Parents: []string{dir.Id}}).Context(r.Context()).Fields(googleapi.Field("id")).Media(f).Do()
 	if err != nil {
 		log.Errorf("failed to upload file: %v", err)
 		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
@@ -627,11 +846,17 @@ func (a *API) initialStateWithSheets(next http.Handler) http.Handler {
 			return
 		}
 		sid, err := sheetFromSession(r)
+		if err != nil && a.config.Store != nil {
+			// There is no sheet to pick when members are not stored in Google Sheets.
+			sid = localSheetID
+			err = a.selectSheet(w, r, sid)
+		}
 		if err != nil {
 			if r.URL.Path != "/sheets" {
 				http.Redirect(w, r, "/sheets", http.StatusFound)
 			}
-			fl, err := a.clients[is.Email].drive.Files.List().Context(r.Context()).Q(sheetsQuery).Do()
+			c, _ := a.client(is.Email)
+			fl, err := c.drive.Files.List().Context(r.Context()).Q(sheetsQuery).Do()
 			if err != nil {
 				log.Errorf("failed to list sheets: %v", err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -644,7 +869,7 @@ func (a *API) initialStateWithSheets(next http.Handler) http.Handler {
 			// The client already has selected a sheet.
 			// Ensure the server state matches.
 			is.SheetID = sid
-			a.sheets[is.Email] = sid
+			a.setSheet(is.Email, sid)
 		}
 		ctx := withInitialState(r.Context(), is)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -668,7 +893,7 @@ func (a *API) initialStateWithClient(next http.Handler) http.Handler {
 			return
 		}
 		if id != "" {
-			u, _, _, err := findUser(is.SheetID, id, bsIDColumn, a.clients[is.Email])
+			u, err := findUser(r.Context(), a.store(is.Email, is.SheetID), id, false)
 			if err != nil {
 				is.ClientError = err.Error()
 			} else {
@@ -702,7 +927,7 @@ func (a *API) requireLogin(next http.Handler) http.Handler {
 // isAuthenticated returns true if the user has a signed session cookie.
 func (a *API) isAuthenticated(r *http.Request) bool {
 	if id, err := idFromSession(r); err == nil {
-		if _, ok := a.clients[id]; ok {
+		if _, ok := a.client(id); ok {
 			return true
 		}
 	}
@@ -743,7 +968,12 @@ func writeJSONError(err error, status int) http.Handler {
 		w.Header().Set("Content-Type", "application/json")
 		data := struct {
 			Error string `json:"error"`
-		}{err.Error()}
+			// User is the conflicting member, if any.
+			User *user `json:"user,omitempty"`
+		}{Error: err.Error()}
+		if c, ok := err.(*conflictError); ok {
+			data.User = c.user
+		}
 		json.NewEncoder(w).Encode(data)
 	}
 	return http.HandlerFunc(fn)
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const testEmail = "staff@example.com"
+
+// newTestAPI returns an API that uses the given store, without any of the background work of New.
+func newTestAPI(s Store) *API {
+	return &API{
+		clients:   map[string]client{testEmail: {}},
+		config:    &Config{Store: s},
+		occupancy: newOccupancy(0, 0, 0, true),
+		policy:    mustPolicy(nil, 0, 0),
+		queue:     newVisitQueue("", prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_visit_queue_depth"})),
+		scans:     newScanTracker(time.Minute, tapDebounce),
+		sheets:    map[string]string{testEmail: localSheetID},
+		taps:      newScanTracker(tapDebounce, 0),
+	}
+}
+
+// newTestRequest returns a request with a signed session, the given route variables, and the given body encoded as JSON.
+func newTestRequest(t *testing.T, method string, vars map[string]string, body interface{}) *http.Request {
+	t.Helper()
+	var b io.Reader = http.NoBody
+	if body != nil {
+		j, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("failed to encode request body: %v", err)
+		}
+		b = bytes.NewReader(j)
+	}
+	w := httptest.NewRecorder()
+	s := sessionStore.New(sessionName)
+	s.Values[sessionIDKey] = testEmail
+	s.Values[sessionSheetKey] = localSheetID
+	if err := s.Save(w); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	r := httptest.NewRequest(method, "/", b)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return mux.SetURLVars(r, vars)
+}
+
+// serve calls the given handler with the given request and fails the test if the response is not OK.
+func serve(t *testing.T, h http.HandlerFunc, r *http.Request) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+// newTestStore returns a memory store with the given members, whose memberships expire in a month.
+func newTestStore(t *testing.T, users ...user) Store {
+	t.Helper()
+	s := NewMemoryStore()
+	for i := range users {
+		if users[i].Expiration.IsZero() {
+			users[i].Expiration = midnight(time.Now()).AddDate(0, 1, 0)
+		}
+		if err := s.CreateUser(context.Background(), &users[i]); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	return s
+}
+
+func TestScanUsesOneCreditPerTap(t *testing.T) {
+	intp := func(i int) *int { return &i }
+	for _, tc := range []struct {
+		name     string
+		credits  *int
+		rules    map[string]string
+		sessions int
+		// concurrent is true if the tap is handled for all sessions at once, like broadcastUser does.
+		concurrent bool
+		expected   *int
+	}{
+		{
+			name:     "no punch card",
+			sessions: 3,
+		},
+		{
+			name:     "one session",
+			credits:  intp(10),
+			sessions: 1,
+			expected: intp(9),
+		},
+		{
+			name:     "several sessions",
+			credits:  intp(10),
+			sessions: 3,
+			expected: intp(9),
+		},
+		{
+			name:       "several concurrent sessions",
+			credits:    intp(10),
+			sessions:   10,
+			concurrent: true,
+			expected:   intp(9),
+		},
+		{
+			name:     "no credits left",
+			credits:  intp(0),
+			rules:    map[string]string{string(reasonNoCredits): string(effectAllow)},
+			sessions: 1,
+			expected: intp(0),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStore(t, user{BSID: "a", ID: "c1", Credits: tc.credits})
+			a := newTestAPI(s)
+			a.policy = mustPolicy(tc.rules, 0, 0)
+			var wg sync.WaitGroup
+			for i := 0; i < tc.sessions; i++ {
+				wg.Add(1)
+				scan := func(id string) {
+					defer wg.Done()
+					e, err := a.scan(s, id, localSheetID, "c1")
+					if err != nil {
+						t.Errorf("failed to scan: %v", err)
+						return
+					}
+					if e.Decision != decisionAllowed {
+						t.Errorf("expected decision %q, got %q", decisionAllowed, e.Decision)
+					}
+				}
+				if tc.concurrent {
+					go scan(fmt.Sprintf("staff%d@example.com", i))
+					continue
+				}
+				scan(fmt.Sprintf("staff%d@example.com", i))
+			}
+			wg.Wait()
+			u, err := s.UserByBSID(context.Background(), "a")
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			if (u.Credits == nil) != (tc.expected == nil) || u.Credits != nil && *u.Credits != *tc.expected {
+				t.Errorf("expected credits %s, got %s", formatCredits(tc.expected), formatCredits(u.Credits))
+			}
+			visits, err := s.Visits(context.Background())
+			if err != nil {
+				t.Fatalf("failed to list visits: %v", err)
+			}
+			if len(visits) != 1 {
+				t.Errorf("expected 1 visit, got %d", len(visits))
+			}
+		})
+	}
+}
+
+func formatCredits(c *int) string {
+	if c == nil {
+		return "none"
+	}
+	return strconv.Itoa(*c)
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// archiveUserHandler allows the client to archive a user who is no longer a member.
+// Archived users can no longer be found by scans, but their visits are kept.
+func (a *API) archiveUserHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	u, err := findUser(r.Context(), s, bsID, false)
+	if err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if err := s.ArchiveUser(r.Context(), bsID); err != nil {
+		log.Errorf("failed to archive user %q: %v", bsID, err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(u).ServeHTTP(w, r)
+}
+
+// deleteUserHandler allows the client to erase all of the data of a user, whether or not they are archived:
+// their row, their visits, cards, debts, and freezes, and their photos.
+func (a *API) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	id, err := idFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	u, err := s.DeleteUser(r.Context(), bsID)
+	if err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		log.Errorf("failed to delete user %q: %v", bsID, err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	c, _ := a.client(id)
+	if err := deletePhotos(r.Context(), c, u); err != nil {
+		log.Errorf("failed to delete the photos of user %q: %v", u.BSID, err)
+		writeJSONError(fmt.Errorf("the user was deleted but their photos were not: %v", err), http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(u).ServeHTTP(w, r)
+}
+
+// deletePhotos deletes the photo of the given user from Drive,
+// along with any earlier photos of theirs in the photo directory.
+func deletePhotos(ctx context.Context, c client, u *user) error {
+	ids := make(map[string]struct{})
+	if u.Photo != "" {
+		ids[u.Photo] = struct{}{}
+	}
+	dir, err := findDirectory(ctx, c)
+	if err != nil {
+		return err
+	}
+	if dir != nil {
+		// Photos are uploaded with the BSID as their name.
+		q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(u.BSID), dir.Id)
+		files, err := c.drive.Files.List().Context(ctx).Q(q).Do()
+		if err != nil {
+			return fmt.Errorf("failed to list photos: %v", err)
+		}
+		for _, f := range files.Files {
+			ids[f.Id] = struct{}{}
+		}
+	}
+	for id := range ids {
+		if err := c.drive.Files.Delete(id).Context(ctx).Do(); err != nil {
+			if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+				continue
+			}
+			return fmt.Errorf("failed to delete photo %q: %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,734 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket   = []byte("users")
+	archiveBucket = []byte("archive")
+	rfidsBucket   = []byte("rfids")
+	cardsBucket   = []byte("cards")
+	debtsBucket   = []byte("debts")
+	freezesBucket = []byte("freezes")
+	passesBucket  = []byte("passes")
+	plansBucket   = []byte("plans")
+	visitsBucket  = []byte("visits")
+)
+
+// record is the representation of a user in the database.
+// It bypasses the validation of user.UnmarshalJSON, which is meant for
+// input from clients.
+type record user
+
+// boltStore is a Store backed by a bbolt database on local disk.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore returns a new Store backed by the bbolt database at the given path.
+// The database is created if it does not already exist.
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{usersBucket, archiveBucket, rfidsBucket, cardsBucket, debtsBucket, freezesBucket, passesBucket, plansBucket, visitsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create database buckets: %v", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+// UserByBSID implements the Store interface.
+func (b *boltStore) UserByBSID(_ context.Context, bsID string) (*user, error) {
+	var u *user
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		u, err = getUser(tx, bsID)
+		return err
+	})
+	return u, err
+}
+
+// Users implements the Store interface.
+func (b *boltStore) Users(_ context.Context) ([]*user, error) {
+	var users []*user
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+			var u user
+			if err := json.Unmarshal(v, (*record)(&u)); err != nil {
+				return fmt.Errorf("failed to parse user: %v", err)
+			}
+			users = append(users, &u)
+			return nil
+		})
+	})
+	return users, err
+}
+
+// UserByRFID implements the Store interface.
+func (b *boltStore) UserByRFID(_ context.Context, rfid string) (*user, error) {
+	var u *user
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bsID := tx.Bucket(rfidsBucket).Get([]byte(strings.ToLower(rfid)))
+		if bsID == nil {
+			return &notFoundError{"user", rfid}
+		}
+		var err error
+		u, err = getUser(tx, string(bsID))
+		return err
+	})
+	return u, err
+}
+
+// UserByEmail implements the Store interface.
+func (b *boltStore) UserByEmail(_ context.Context, email string) (*user, error) {
+	var found *user
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+			var u user
+			if err := json.Unmarshal(v, (*record)(&u)); err != nil {
+				return fmt.Errorf("failed to parse user: %v", err)
+			}
+			if found == nil && u.Email != "" && strings.EqualFold(u.Email, email) {
+				found = &u
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, &notFoundError{"user", email}
+	}
+	return found, nil
+}
+
+// CreateUser implements the Store interface.
+func (b *boltStore) CreateUser(_ context.Context, u *user) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(usersBucket).Get([]byte(strings.ToLower(u.BSID))) != nil {
+			return fmt.Errorf("user %q already exists", u.BSID)
+		}
+		return putUser(tx, u)
+	})
+}
+
+// CreateUsers implements the Store interface.
+func (b *boltStore) CreateUsers(_ context.Context, users []*user) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, u := range users {
+			if tx.Bucket(usersBucket).Get([]byte(strings.ToLower(u.BSID))) != nil {
+				return fmt.Errorf("user %q already exists", u.BSID)
+			}
+			if err := putUser(tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateUser implements the Store interface.
+func (b *boltStore) UpdateUser(_ context.Context, bsID string, u *user) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getUser(tx, bsID)
+		if err != nil {
+			return err
+		}
+		if err := deleteUser(tx, existing); err != nil {
+			return err
+		}
+		mergeUser(existing, u)
+		return putUser(tx, existing)
+	})
+}
+
+// ArchiveUser implements the Store interface.
+func (b *boltStore) ArchiveUser(_ context.Context, bsID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		u, err := getUser(tx, bsID)
+		if err != nil {
+			return err
+		}
+		if err := deleteUser(tx, u); err != nil {
+			return err
+		}
+		return putArchived(tx, u)
+	})
+}
+
+// ArchivedUsers implements the Store interface.
+func (b *boltStore) ArchivedUsers(_ context.Context) ([]*user, error) {
+	var users []*user
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(archiveBucket).ForEach(func(_, v []byte) error {
+			var u user
+			if err := json.Unmarshal(v, (*record)(&u)); err != nil {
+				return fmt.Errorf("failed to parse user: %v", err)
+			}
+			users = append(users, &u)
+			return nil
+		})
+	})
+	return users, err
+}
+
+// DeleteUser implements the Store interface.
+func (b *boltStore) DeleteUser(_ context.Context, bsID string) (*user, error) {
+	var u *user
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if u, err = getUser(tx, bsID); err == nil {
+			if err := deleteUser(tx, u); err != nil {
+				return err
+			}
+		} else {
+			if _, ok := err.(*notFoundError); !ok {
+				return err
+			}
+			a := tx.Bucket(archiveBucket)
+			v := a.Get([]byte(strings.ToLower(bsID)))
+			if v == nil {
+				return err
+			}
+			u = new(user)
+			if err := json.Unmarshal(v, (*record)(u)); err != nil {
+				return fmt.Errorf("failed to parse user: %v", err)
+			}
+			if err := a.Delete([]byte(strings.ToLower(bsID))); err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{visitsBucket, cardsBucket, debtsBucket, freezesBucket} {
+			// Collect the keys first, since a bucket must not be modified while iterating over it.
+			var keys [][]byte
+			c := tx.Bucket(name).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var r struct {
+					BSID string `json:"bsID"`
+				}
+				if err := json.Unmarshal(v, &r); err != nil {
+					return fmt.Errorf("failed to parse entry of bucket %q: %v", name, err)
+				}
+				if r.BSID == u.BSID {
+					keys = append(keys, k)
+				}
+			}
+			for _, k := range keys {
+				if err := tx.Bucket(name).Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ReassignCard implements the Store interface.
+func (b *boltStore) ReassignCard(_ context.Context, rfid, bsID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		u, err := getUser(tx, bsID)
+		if err != nil {
+			return err
+		}
+		if holder := tx.Bucket(rfidsBucket).Get([]byte(strings.ToLower(rfid))); holder != nil && string(holder) != u.BSID {
+			prev, err := getUser(tx, string(holder))
+			if err != nil {
+				return err
+			}
+			if err := deleteUser(tx, prev); err != nil {
+				return err
+			}
+			prev.ID = ""
+			if err := putUser(tx, prev); err != nil {
+				return err
+			}
+		}
+		if err := deleteUser(tx, u); err != nil {
+			return err
+		}
+		u.ID = strings.ToLower(rfid)
+		return putUser(tx, u)
+	})
+}
+
+// Debts implements the Store interface.
+func (b *boltStore) Debts(_ context.Context, bsID string) ([]debt, error) {
+	var debts []debt
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(debtsBucket).ForEach(func(_, v []byte) error {
+			var d debt
+			if err := json.Unmarshal(v, &d); err != nil {
+				return fmt.Errorf("failed to parse debt: %v", err)
+			}
+			if d.BSID == strings.ToLower(bsID) {
+				debts = append(debts, d)
+			}
+			return nil
+		})
+	})
+	return debts, err
+}
+
+// AddDebt implements the Store interface.
+func (b *boltStore) AddDebt(_ context.Context, d *debt) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putDebt(tx, d)
+	})
+}
+
+// SettleDebt implements the Store interface.
+func (b *boltStore) SettleDebt(_ context.Context, bsID string, id int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(debtsBucket)
+		v := bucket.Get(itob(uint64(id)))
+		if v == nil {
+			return &notFoundError{"debt", strconv.Itoa(id)}
+		}
+		var d debt
+		if err := json.Unmarshal(v, &d); err != nil {
+			return fmt.Errorf("failed to parse debt: %v", err)
+		}
+		if d.BSID != strings.ToLower(bsID) {
+			return &notFoundError{"debt", strconv.Itoa(id)}
+		}
+		d.Settled = true
+		j, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(uint64(id)), j)
+	})
+}
+
+// Freezes implements the Store interface.
+func (b *boltStore) Freezes(_ context.Context, bsID string) ([]freeze, error) {
+	var freezes []freeze
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(freezesBucket).ForEach(func(_, v []byte) error {
+			var f freeze
+			if err := json.Unmarshal(v, &f); err != nil {
+				return fmt.Errorf("failed to parse freeze: %v", err)
+			}
+			if f.BSID == strings.ToLower(bsID) {
+				freezes = append(freezes, f)
+			}
+			return nil
+		})
+	})
+	return freezes, err
+}
+
+// AddFreeze implements the Store interface.
+func (b *boltStore) AddFreeze(_ context.Context, f *freeze) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putFreeze(tx, f)
+	})
+}
+
+// EndFreeze implements the Store interface.
+func (b *boltStore) EndFreeze(_ context.Context, bsID string, id int, end time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(freezesBucket)
+		v := bucket.Get(itob(uint64(id)))
+		if v == nil {
+			return &notFoundError{"freeze", strconv.Itoa(id)}
+		}
+		var f freeze
+		if err := json.Unmarshal(v, &f); err != nil {
+			return fmt.Errorf("failed to parse freeze: %v", err)
+		}
+		if f.BSID != strings.ToLower(bsID) {
+			return &notFoundError{"freeze", strconv.Itoa(id)}
+		}
+		f.endAt(end)
+		j, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(itob(uint64(id)), j)
+	})
+}
+
+// Cards implements the Store interface.
+func (b *boltStore) Cards(_ context.Context, bsID string) ([]card, error) {
+	var cards []card
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(cardsBucket).ForEach(func(_, v []byte) error {
+			var c card
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("failed to parse card: %v", err)
+			}
+			if c.BSID == strings.ToLower(bsID) {
+				cards = append(cards, c)
+			}
+			return nil
+		})
+	})
+	return cards, err
+}
+
+// CardByRFID implements the Store interface.
+func (b *boltStore) CardByRFID(_ context.Context, rfid string) (*card, error) {
+	var found *card
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// Walk the history backwards so that the most recent entry is found first.
+		c := tx.Bucket(cardsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var cd card
+			if err := json.Unmarshal(v, &cd); err != nil {
+				return fmt.Errorf("failed to parse card: %v", err)
+			}
+			if cd.RFID == strings.ToLower(rfid) {
+				found = &cd
+				return nil
+			}
+		}
+		return &notFoundError{"card", rfid}
+	})
+	return found, err
+}
+
+// AddCard implements the Store interface.
+func (b *boltStore) AddCard(_ context.Context, c *card) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putCard(tx, c)
+	})
+}
+
+// Plans implements the Store interface.
+func (b *boltStore) Plans(_ context.Context) ([]plan, error) {
+	var plans []plan
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(plansBucket).ForEach(func(_, v []byte) error {
+			var p plan
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("failed to parse plan: %v", err)
+			}
+			plans = append(plans, p)
+			return nil
+		})
+	})
+	return plans, err
+}
+
+// CreatePlan implements the Store interface.
+func (b *boltStore) CreatePlan(_ context.Context, p *plan) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		j, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(plansBucket).Put([]byte(strings.ToLower(p.Name)), j)
+	})
+}
+
+// Passes implements the Store interface.
+func (b *boltStore) Passes(_ context.Context) ([]pass, error) {
+	var passes []pass
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(passesBucket).ForEach(func(_, v []byte) error {
+			var p pass
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("failed to parse pass: %v", err)
+			}
+			passes = append(passes, p)
+			return nil
+		})
+	})
+	return passes, err
+}
+
+// CreatePass implements the Store interface.
+func (b *boltStore) CreatePass(_ context.Context, p *pass) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		seq, err := tx.Bucket(passesBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		p.ID = int(seq)
+		return putPass(tx, p)
+	})
+}
+
+// Roster implements the Store interface.
+func (b *boltStore) Roster(_ context.Context) (*roster, error) {
+	var users []*user
+	cards := make(map[string][]card)
+	debts := make(map[string][]debt)
+	freezes := make(map[string][]freeze)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+			var u user
+			if err := json.Unmarshal(v, (*record)(&u)); err != nil {
+				return fmt.Errorf("failed to parse user: %v", err)
+			}
+			users = append(users, &u)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(cardsBucket).ForEach(func(_, v []byte) error {
+			var c card
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("failed to parse card: %v", err)
+			}
+			cards[c.BSID] = append(cards[c.BSID], c)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(debtsBucket).ForEach(func(_, v []byte) error {
+			var d debt
+			if err := json.Unmarshal(v, &d); err != nil {
+				return fmt.Errorf("failed to parse debt: %v", err)
+			}
+			debts[d.BSID] = append(debts[d.BSID], d)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(freezesBucket).ForEach(func(_, v []byte) error {
+			var f freeze
+			if err := json.Unmarshal(v, &f); err != nil {
+				return fmt.Errorf("failed to parse freeze: %v", err)
+			}
+			freezes[f.BSID] = append(freezes[f.BSID], f)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRoster(users, cards, debts, freezes), nil
+}
+
+// Visits implements the Store interface.
+func (b *boltStore) Visits(_ context.Context) ([]visit, error) {
+	var visits []visit
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(visitsBucket).ForEach(func(_, v []byte) error {
+			var vi visit
+			if err := json.Unmarshal(v, &vi); err != nil {
+				return fmt.Errorf("failed to parse visit: %v", err)
+			}
+			visits = append(visits, vi)
+			return nil
+		})
+	})
+	return visits, err
+}
+
+// RecordVisit implements the Store interface.
+func (b *boltStore) RecordVisit(_ context.Context, v *visit) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putVisit(tx, *v)
+	})
+}
+
+// load implements the loader interface.
+// The database must be empty, since the cards, debts, freezes, and visits of the snapshot are appended.
+func (b *boltStore) load(_ context.Context, s *snapshot) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{usersBucket, archiveBucket, rfidsBucket, cardsBucket, debtsBucket, freezesBucket, passesBucket, plansBucket, visitsBucket} {
+			if k, _ := tx.Bucket(name).Cursor().First(); k != nil {
+				return &notEmptyError{string(name)}
+			}
+		}
+		for _, u := range s.Users {
+			if err := putUser(tx, u); err != nil {
+				return err
+			}
+		}
+		for _, u := range s.Archived {
+			if err := putArchived(tx, u); err != nil {
+				return err
+			}
+		}
+		for _, c := range s.Cards {
+			if err := putCard(tx, &c); err != nil {
+				return err
+			}
+		}
+		for _, d := range s.Debts {
+			if err := putDebt(tx, &d); err != nil {
+				return err
+			}
+		}
+		for _, f := range s.Freezes {
+			if err := putFreeze(tx, &f); err != nil {
+				return err
+			}
+		}
+		// Keep the IDs of passes so that the visits of their holders still refer to them.
+		for _, p := range s.Passes {
+			if err := putPass(tx, &p); err != nil {
+				return err
+			}
+			if b := tx.Bucket(passesBucket); uint64(p.ID) > b.Sequence() {
+				if err := b.SetSequence(uint64(p.ID)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, p := range s.Plans {
+			j, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(plansBucket).Put([]byte(strings.ToLower(p.Name)), j); err != nil {
+				return err
+			}
+		}
+		for _, v := range s.Visits {
+			if err := putVisit(tx, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getUser(tx *bolt.Tx, bsID string) (*user, error) {
+	v := tx.Bucket(usersBucket).Get([]byte(strings.ToLower(bsID)))
+	if v == nil {
+		return nil, &notFoundError{"user", bsID}
+	}
+	var u user
+	if err := json.Unmarshal(v, (*record)(&u)); err != nil {
+		return nil, fmt.Errorf("failed to parse user: %v", err)
+	}
+	return &u, nil
+}
+
+func putUser(tx *bolt.Tx, u *user) error {
+	v, err := json.Marshal((*record)(u))
+	if err != nil {
+		return err
+	}
+	bsID := []byte(strings.ToLower(u.BSID))
+	if u.ID != "" {
+		if err := tx.Bucket(rfidsBucket).Put([]byte(strings.ToLower(u.ID)), bsID); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(usersBucket).Put(bsID, v)
+}
+
+func deleteUser(tx *bolt.Tx, u *user) error {
+	if u.ID != "" {
+		if err := tx.Bucket(rfidsBucket).Delete([]byte(strings.ToLower(u.ID))); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(usersBucket).Delete([]byte(strings.ToLower(u.BSID)))
+}
+
+// putArchived adds the given user to the archive.
+func putArchived(tx *bolt.Tx, u *user) error {
+	v, err := json.Marshal((*record)(u))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(archiveBucket).Put([]byte(strings.ToLower(u.BSID)), v)
+}
+
+// putCard adds the given entry to the card history and sets its ID.
+func putCard(tx *bolt.Tx, c *card) error {
+	b := tx.Bucket(cardsBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	c.ID = int(seq)
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(seq), j)
+}
+
+// putDebt adds the given debt to the ledger and sets its ID.
+func putDebt(tx *bolt.Tx, d *debt) error {
+	b := tx.Bucket(debtsBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	d.ID = int(seq)
+	j, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(seq), j)
+}
+
+// putFreeze adds the given freeze and sets its ID.
+func putFreeze(tx *bolt.Tx, f *freeze) error {
+	b := tx.Bucket(freezesBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	f.ID = int(seq)
+	j, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(seq), j)
+}
+
+func putPass(tx *bolt.Tx, p *pass) error {
+	j, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(passesBucket).Put(itob(uint64(p.ID)), j)
+}
+
+func putVisit(tx *bolt.Tx, v visit) error {
+	b := tx.Bucket(visitsBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(seq), j)
+}
+
+// itob returns an 8-byte big endian representation of the given integer,
+// which keeps sequential keys sorted.
+func itob(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// cardStatus is the state of an RFID card.
+type cardStatus string
+
+const (
+	cardActive  cardStatus = "active"
+	cardLost    cardStatus = "lost"
+	cardRetired cardStatus = "retired"
+)
+
+// card is an entry in the history of the RFID cards of a member.
+// The history is append-only: the most recent entry for a card determines its status.
+// The card in the RFID column of the member sheet is active unless the history says otherwise.
+type card struct {
+	// ID identifies the entry; for Google Sheets, it is the row of the entry.
+	ID      int        `json:"id"`
+	BSID    string     `json:"bsID"`
+	RFID    string     `json:"rfid"`
+	Status  cardStatus `json:"status"`
+	Changed time.Time  `json:"changed"`
+}
+
+// latestCards returns the most recent entry for each card in the given history, keyed by RFID.
+func latestCards(cards []card) map[string]card {
+	latest := make(map[string]card)
+	for _, c := range cards {
+		if l, ok := latest[c.RFID]; !ok || c.ID > l.ID {
+			latest[c.RFID] = c
+		}
+	}
+	return latest
+}
+
+// findCardholder will look for the holder of the given card, first in the card history and then in the member sheet.
+// If the card is lost or retired, the user is nil and the card names its former owner.
+func findCardholder(ctx context.Context, s Store, rfid string) (*user, *card, error) {
+	c, err := s.CardByRFID(ctx, rfid)
+	if err != nil {
+		if _, ok := err.(*notFoundError); !ok {
+			return nil, nil, fmt.Errorf("failed to get card: %v", err)
+		}
+		u, err := findUser(ctx, s, rfid, true)
+		return u, nil, err
+	}
+	if c.Status != cardActive {
+		return nil, c, nil
+	}
+	u, err := findUser(ctx, s, c.BSID, false)
+	return u, c, err
+}
+
+// checkCardAvailable returns a *conflictError if the given card is in use by any member other than the one with the given BSID.
+func checkCardAvailable(ctx context.Context, s Store, rfid, bsID string) error {
+	t, err := newTakenIDs(ctx, s, false)
+	if err != nil {
+		return err
+	}
+	return t.checkCard(rfid, bsID)
+}
+
+// claimCard makes the given card an active card of the member with the given BSID in the card history
+// if the history says otherwise, e.g. because the card was retired by its former owner before it was handed out again.
+// Cards without a history are left alone, since the card in the RFID column of the member sheet is active anyway.
+func claimCard(ctx context.Context, s Store, rfid, bsID string, now time.Time) error {
+	rfid, bsID = strings.ToLower(rfid), strings.ToLower(bsID)
+	c, err := s.CardByRFID(ctx, rfid)
+	if err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	if c.BSID == bsID && c.Status == cardActive {
+		return nil
+	}
+	return s.AddCard(ctx, &card{BSID: bsID, RFID: rfid, Status: cardActive, Changed: now})
+}
+
+// rowToCard converts a row of the card sheet to a card struct.
+func rowToCard(row []interface{}, id int) (*card, error) {
+	cells := make([]string, 4)
+	for i := range row {
+		if i >= len(cells) {
+			break
+		}
+		v, ok := row[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse card field %d", i+1)
+		}
+		cells[i] = strings.TrimSpace(v)
+	}
+	if cells[0] == "" {
+		return nil, errors.New("the card has no BSID")
+	}
+	if cells[1] == "" {
+		return nil, errors.New("the card has no RFID")
+	}
+	c := &card{ID: id, BSID: strings.ToLower(cells[0]), RFID: strings.ToLower(cells[1]), Status: cardStatus(strings.ToLower(cells[2]))}
+	switch c.Status {
+	case cardActive, cardLost, cardRetired:
+	case "":
+		c.Status = cardActive
+	default:
+		return nil, fmt.Errorf("%q is not a valid card status", cells[2])
+	}
+	if cells[3] != "" {
+		var err error
+		if c.Changed, err = time.ParseInLocation(dateFormat, cells[3], loc); err != nil {
+			return nil, fmt.Errorf("failed to parse card change as date %v", err)
+		}
+	}
+	return c, nil
+}
+
+func cardToRow(c *card) []interface{} {
+	return []interface{}{strings.ToLower(c.BSID), strings.ToLower(c.RFID), string(c.Status), c.Changed.Format(dateFormat)}
+}
+
+// getCardsHandler allows the client to list the card history of a user.
+func (a *API) getCardsHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	cards, err := s.Cards(r.Context(), bsID)
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if cards == nil {
+		cards = []card{}
+	}
+	writeJSON(cards).ServeHTTP(w, r)
+}
+
+// addCardHandler allows the client to give a user an additional card.
+func (a *API) addCardHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	var req struct {
+		RFID string `json:"rfid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	defer r.Body.Close()
+	if strings.TrimSpace(req.RFID) == "" {
+		writeJSONError(errors.New("the card has no RFID"), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if _, err := s.UserByBSID(r.Context(), bsID); err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	c := card{BSID: strings.ToLower(bsID), RFID: strings.ToLower(strings.TrimSpace(req.RFID)), Status: cardActive, Changed: midnight(time.Now())}
+	if err := checkCardAvailable(r.Context(), s, c.RFID, bsID); err != nil {
+		if _, ok := err.(*conflictError); ok {
+			writeJSONError(err, http.StatusConflict).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if err := s.AddCard(r.Context(), &c); err != nil {
+		log.Errorf("failed to add card: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(c).ServeHTTP(w, r)
+}
+
+// retireCardHandler returns a handler that allows the client to mark a card of a user as lost or retired.
+// If a replacement card is given, it takes the place of the old card.
+func (a *API) retireCardHandler(status cardStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		bsID := mux.Vars(r)["id"]
+		rfid := strings.ToLower(mux.Vars(r)["rfid"])
+		s, err := a.storeFromSession(r)
+		if err != nil {
+			writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+		var req struct {
+			Replacement string `json:"replacement"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+				return
+			}
+			defer r.Body.Close()
+		}
+		req.Replacement = strings.ToLower(strings.TrimSpace(req.Replacement))
+		u, err := findUser(r.Context(), s, bsID, false)
+		if err != nil {
+			if _, ok := err.(*notFoundError); ok {
+				writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+				return
+			}
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		if c, ok := latestCards(u.Cards)[rfid]; rfid != u.ID && (!ok || c.Status != cardActive) {
+			writeJSONError(&notFoundError{"card", rfid}, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		if req.Replacement != "" {
+			if err := checkCardAvailable(r.Context(), s, req.Replacement, bsID); err != nil {
+				if _, ok := err.(*conflictError); ok {
+					writeJSONError(err, http.StatusConflict).ServeHTTP(w, r)
+					return
+				}
+				writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+				return
+			}
+		}
+		now := midnight(time.Now())
+		if err := s.AddCard(r.Context(), &card{BSID: u.BSID, RFID: rfid, Status: status, Changed: now}); err != nil {
+			log.Errorf("failed to retire card: %v", err)
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case req.Replacement == "":
+		case rfid == u.ID:
+			// The replacement becomes the card in the member sheet.
+			if err = s.UpdateUser(r.Context(), u.BSID, &user{ID: req.Replacement}); err == nil {
+				err = claimCard(r.Context(), s, req.Replacement, u.BSID, now)
+			}
+		default:
+			err = s.AddCard(r.Context(), &card{BSID: u.BSID, RFID: req.Replacement, Status: cardActive, Changed: now})
+		}
+		if err != nil {
+			log.Errorf("failed to add replacement card: %v", err)
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		if u, err = findUser(r.Context(), s, bsID, false); err != nil {
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		writeJSON(u).ServeHTTP(w, r)
+	}
+}
+
+// reassignCardHandler allows the client to make a card the card of a user in a single step,
+// taking it away from whichever member held it before.
+// The card that the user held before is retired.
+func (a *API) reassignCardHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	rfid := strings.ToLower(mux.Vars(r)["rfid"])
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	u, err := s.UserByBSID(r.Context(), bsID)
+	if err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if err := s.ReassignCard(r.Context(), rfid, bsID); err != nil {
+		log.Errorf("failed to reassign card: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	now := midnight(time.Now())
+	if u.ID != "" && u.ID != rfid {
+		if err := s.AddCard(r.Context(), &card{BSID: u.BSID, RFID: u.ID, Status: cardRetired, Changed: now}); err != nil {
+			log.Errorf("failed to retire the replaced card of user %q: %v", u.BSID, err)
+		}
+	}
+	// If the card has a history, e.g. because it was reported lost, it must now name its new owner.
+	if err := claimCard(r.Context(), s, rfid, u.BSID, now); err != nil {
+		log.Errorf("failed to add card to the history of user %q: %v", u.BSID, err)
+	}
+	if u, err = findUser(r.Context(), s, bsID, false); err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(u).ServeHTTP(w, r)
+}
+
+// blockedCardEvent returns the scan event for a lost or retired card.
+func blockedCardEvent(ctx context.Context, s Store, c *card, p *policy) scanEvent {
+	owner := c.BSID
+	if u, err := s.UserByBSID(ctx, c.BSID); err == nil && u.Name != "" {
+		owner = u.Name
+	}
+	e := scanEvent{Card: c, Owner: owner}
+	r := reasonLostCard
+	e.Error = fmt.Sprintf("card %q was reported lost by %s", c.RFID, owner)
+	if c.Status == cardRetired {
+		r = reasonRetiredCard
+		e.Error = fmt.Sprintf("card %q was retired by %s", c.RFID, owner)
+	}
+	e.Decision, e.Reasons = p.decide([]reason{r})
+	return e
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRecycledCard(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// recycle hands out the card "c1", which "a" held before, after it was retired or lost.
+		recycle  func(t *testing.T, a *API)
+		bsID     string
+		decision decision
+		reasons  []reason
+	}{
+		{
+			name:     "retired and not handed out",
+			recycle:  func(t *testing.T, a *API) {},
+			decision: decisionDenied,
+			reasons:  []reason{reasonRetiredCard},
+		},
+		{
+			name: "retired and reassigned via update",
+			recycle: func(t *testing.T, a *API) {
+				serve(t, a.updateUserHandler, newTestRequest(t, http.MethodPut, map[string]string{"id": "b"}, map[string]string{"id": "c1"}))
+			},
+			bsID:     "b",
+			decision: decisionAllowed,
+			reasons:  []reason{},
+		},
+		{
+			name: "retired and handed out via create",
+			recycle: func(t *testing.T, a *API) {
+				serve(t, a.createUserHandler, newTestRequest(t, http.MethodPost, nil, map[string]string{
+					"bsID":       "c",
+					"id":         "c1",
+					"expiration": midnight(time.Now()).AddDate(0, 1, 0).Format(time.RFC3339),
+				}))
+			},
+			bsID:     "c",
+			decision: decisionAllowed,
+			reasons:  []reason{},
+		},
+		{
+			name: "retired and bound to a day pass",
+			recycle: func(t *testing.T, a *API) {
+				serve(t, a.createPassHandler, newTestRequest(t, http.MethodPost, nil, map[string]string{"name": "Guest", "rfid": "c1"}))
+			},
+			decision: decisionAllowed,
+			reasons:  []reason{},
+		},
+		{
+			name: "retired and handed out as a replacement",
+			recycle: func(t *testing.T, a *API) {
+				serve(t, a.retireCardHandler(cardLost), newTestRequest(t, http.MethodPost, map[string]string{"id": "b", "rfid": "c2"}, map[string]string{"replacement": "c1"}))
+			},
+			bsID:     "b",
+			decision: decisionAllowed,
+			reasons:  []reason{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStore(t, user{BSID: "a", ID: "c1"}, user{BSID: "b", ID: "c2"})
+			a := newTestAPI(s)
+			serve(t, a.retireCardHandler(cardRetired), newTestRequest(t, http.MethodPost, map[string]string{"id": "a", "rfid": "c1"}, map[string]string{"replacement": "c3"}))
+			tc.recycle(t, a)
+			e, err := a.scan(s, testEmail, localSheetID, "C1")
+			if err != nil {
+				t.Fatalf("failed to scan: %v", err)
+			}
+			var bsID string
+			if e.user != nil {
+				bsID = e.user.BSID
+			}
+			if bsID != tc.bsID {
+				t.Errorf("expected the card to belong to %q, got %q", tc.bsID, bsID)
+			}
+			if e.Decision != tc.decision {
+				t.Errorf("expected decision %q, got %q: %s", tc.decision, e.Decision, e.Error)
+			}
+			if !equalReasons(e.Reasons, tc.reasons) {
+				t.Errorf("expected reasons %v, got %v", tc.reasons, e.Reasons)
+			}
+			if err := checkCardAvailable(context.Background(), s, "c1", tc.bsID); err != nil {
+				t.Errorf("expected the card to be available to %q: %v", tc.bsID, err)
+			}
+		})
+	}
+}
+
+func equalReasons(a, b []reason) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
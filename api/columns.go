@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// field identifies a member field that is held in a column of the member sheet.
+type field string
+
+const (
+	bsIDField       field = "bsid"
+	expirationField field = "expiration"
+	nameField       field = "name"
+	emailField      field = "email"
+	rfidField       field = "rfid"
+	photoField      field = "photo"
+	planField       field = "plan"
+	creditsField    field = "credits"
+)
+
+var (
+	// defaultHeaders are the headers of the columns that hold each field,
+	// unless they are overridden in the configuration.
+	defaultHeaders = map[field]string{
+		bsIDField:       "BSID",
+		expirationField: "Expiration",
+		nameField:       "Name",
+		emailField:      "Email",
+		rfidField:       "RFID",
+		photoField:      "Photo",
+		planField:       "Plan",
+		creditsField:    "Credits",
+	}
+	// fields are all of the member fields, in the order they are reported.
+	fields = []field{bsIDField, expirationField, nameField, emailField, rfidField, photoField, planField, creditsField}
+	// requiredFields are the fields that every member sheet must have.
+	requiredFields = []field{bsIDField, expirationField, nameField}
+)
+
+// missingHeaderError is the error type returned when the member sheet
+// does not have a column for a required field.
+type missingHeaderError struct {
+	field  field
+	header string
+}
+
+// Error implements the error interface.
+func (e *missingHeaderError) Error() string {
+	return fmt.Sprintf("the member sheet has no %q header for the required %s column", e.header, e.field)
+}
+
+// columns maps the member fields to the indices of the columns that hold them.
+type columns struct {
+	index map[field]int
+	width int
+}
+
+// newColumns maps the member fields to columns by finding their headers in the given header row.
+// The given headers override the default header of a field; header names are not case sensitive.
+func newColumns(header []interface{}, headers map[string]string) (*columns, error) {
+	names := make(map[field]string, len(defaultHeaders))
+	for f, h := range defaultHeaders {
+		names[f] = h
+	}
+	for f, h := range headers {
+		if _, ok := defaultHeaders[field(f)]; !ok {
+			return nil, fmt.Errorf("%q is not a known member field", f)
+		}
+		names[field(f)] = h
+	}
+	c := &columns{index: make(map[field]int), width: len(header)}
+	for i := range header {
+		h, ok := header[i].(string)
+		if !ok {
+			continue
+		}
+		for f, name := range names {
+			if _, ok := c.index[f]; !ok && strings.EqualFold(strings.TrimSpace(h), name) {
+				c.index[f] = i
+			}
+		}
+	}
+	for _, f := range requiredFields {
+		if _, ok := c.index[f]; !ok {
+			return nil, &missingHeaderError{f, names[f]}
+		}
+	}
+	return c, nil
+}
+
+// get returns the value of the given field in the given row.
+// If the sheet has no column for the field or the row is too short, the value is empty.
+func (c *columns) get(row []interface{}, f field) (string, error) {
+	i, ok := c.index[f]
+	if !ok || i >= len(row) {
+		return "", nil
+	}
+	v, ok := row[i].(string)
+	if !ok {
+		return "", fmt.Errorf("failed to parse row field %q", f)
+	}
+	return v, nil
+}
+
+// columnName returns the name of the column with the given index in A1 notation, e.g. "C" for 2.
+// The member sheet spans at most the columns A to Z.
+func columnName(i int) string {
+	return string(rune('A' + i))
+}
+
+// set sets the value of the given field in the given row, if the sheet has a column for it.
+func (c *columns) set(row []interface{}, f field, v interface{}) {
+	if i, ok := c.index[f]; ok {
+		row[i] = v
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// debt is an entry in the debt ledger of a member.
+type debt struct {
+	// ID identifies the entry; for Google Sheets, it is the row of the entry.
+	ID   int    `json:"id"`
+	BSID string `json:"bsID"`
+	// Amount is the amount owed in cents.
+	Amount  int64     `json:"amount"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+	Settled bool      `json:"settled"`
+}
+
+// setDebts populates the debt fields of the given user with the given ledger entries.
+func setDebts(u *user, debts []debt) {
+	u.Debts = debts
+	u.Debt = false
+	u.DebtTotal = 0
+	for _, d := range debts {
+		if !d.Settled {
+			u.Debt = true
+			u.DebtTotal += d.Amount
+		}
+	}
+}
+
+// rowToDebt converts a row of the debt sheet to a debt struct.
+// Rows that only hold a BSID predate the ledger and are treated as unsettled debt of unknown amount.
+func rowToDebt(row []interface{}, id int) (*debt, error) {
+	cells := make([]string, 5)
+	for i := range row {
+		if i >= len(cells) {
+			break
+		}
+		v, ok := row[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse debt field %d", i+1)
+		}
+		cells[i] = strings.TrimSpace(v)
+	}
+	if cells[0] == "" {
+		return nil, errors.New("the debt has no BSID")
+	}
+	d := &debt{ID: id, BSID: strings.ToLower(cells[0]), Reason: cells[2]}
+	var err error
+	if cells[1] != "" {
+		if d.Amount, err = parseAmount(cells[1]); err != nil {
+			return nil, err
+		}
+	}
+	if cells[3] != "" {
+		if d.Created, err = time.ParseInLocation(dateFormat, cells[3], loc); err != nil {
+			return nil, fmt.Errorf("failed to parse debt creation as date %v", err)
+		}
+	}
+	switch strings.ToLower(cells[4]) {
+	case "", "false", "no", "0":
+	default:
+		d.Settled = true
+	}
+	return d, nil
+}
+
+func debtToRow(d *debt) []interface{} {
+	return []interface{}{
+		strings.ToLower(d.BSID),
+		formatAmount(d.Amount),
+		d.Reason,
+		d.Created.Format(dateFormat),
+		strings.ToUpper(strconv.FormatBool(d.Settled)),
+	}
+}
+
+// parseAmount parses an amount of money like "12.50", "12,50" or "€12" into cents.
+func parseAmount(s string) (int64, error) {
+	a := strings.TrimSpace(strings.Trim(strings.TrimSpace(s), "€"))
+	a = strings.Replace(a, ",", ".", 1)
+	f, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q as an amount of money", s)
+	}
+	return int64(math.Round(f * 100)), nil
+}
+
+// formatAmount formats an amount of cents as a decimal number.
+func formatAmount(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// addDebtHandler allows the client to add an entry to the debt ledger of a user.
+func (a *API) addDebtHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	var d debt
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	defer r.Body.Close()
+	if d.Amount <= 0 {
+		writeJSONError(errors.New("the amount must be positive"), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if _, err := s.UserByBSID(r.Context(), bsID); err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	d.BSID = strings.ToLower(bsID)
+	d.Created = time.Now().In(loc)
+	d.Settled = false
+	if err := s.AddDebt(r.Context(), &d); err != nil {
+		log.Errorf("failed to add debt: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(d).ServeHTTP(w, r)
+}
+
+// settleDebtHandler allows the client to mark an entry in the debt ledger of a user as settled.
+func (a *API) settleDebtHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	id, err := strconv.Atoi(mux.Vars(r)["debt"])
+	if err != nil {
+		writeJSONError(fmt.Errorf("%q is not a valid debt ID", mux.Vars(r)["debt"]), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if err := s.SettleDebt(r.Context(), bsID, id); err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		log.Errorf("failed to settle debt: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	u, err := findUser(r.Context(), s, bsID, false)
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(u).ServeHTTP(w, r)
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExportFormat is a file format in which members and visits can be exported.
+type ExportFormat string
+
+const (
+	// ExportCSV writes a header row followed by one row per record.
+	ExportCSV ExportFormat = "csv"
+	// ExportJSONLines writes one JSON object per line.
+	ExportJSONLines ExportFormat = "jsonl"
+	// ExportXLSX writes an Excel workbook with a single worksheet.
+	ExportXLSX ExportFormat = "xlsx"
+)
+
+// contentType returns the media type of files in the given format.
+func (f ExportFormat) contentType() string {
+	switch f {
+	case ExportJSONLines:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ExportOptions select the records of an export and the format in which they are written.
+type ExportOptions struct {
+	// Format is the format of the export; it defaults to CSV.
+	Format ExportFormat
+	// Statuses restrict the export to members in any of the given states, e.g. active or debt.
+	Statuses []string
+	// From and To restrict the export to the days between them, inclusive; zero times are open ends.
+	// Members are selected by their effective expiration and visits by their time.
+	From, To time.Time
+	// SoonDays is the number of days before a membership expires during which it counts as expiring.
+	SoonDays int
+}
+
+// exportQuery holds the validated options of an export.
+type exportQuery struct {
+	format   ExportFormat
+	statuses []memberStatus
+	from     time.Time
+	// until is the start of the day after the last day of the export.
+	until  time.Time
+	policy *policy
+}
+
+// newExportQuery validates the given options.
+func newExportQuery(o ExportOptions) (*exportQuery, error) {
+	q := exportQuery{format: o.Format, from: o.From, policy: &policy{soonDays: o.SoonDays}}
+	switch q.format {
+	case "":
+		q.format = ExportCSV
+	case ExportCSV, ExportJSONLines, ExportXLSX:
+	default:
+		return nil, fmt.Errorf("%q is not a valid format; expected one of csv, jsonl, or xlsx", o.Format)
+	}
+	var err error
+	if q.statuses, err = parseStatuses(o.Statuses); err != nil {
+		return nil, err
+	}
+	if !o.To.IsZero() {
+		q.until = o.To.AddDate(0, 0, 1)
+	}
+	return &q, nil
+}
+
+// within returns true if the given time falls within the days of the export.
+func (q *exportQuery) within(t time.Time) bool {
+	return (q.from.IsZero() || !t.Before(q.from)) && (q.until.IsZero() || t.Before(q.until))
+}
+
+// exportRecord is a record of an export: its JSON value and its cells in the order of the header.
+type exportRecord struct {
+	value interface{}
+	cells []string
+}
+
+// exportSource reads the records that the given export query selects, e.g. the members or the visits.
+// It returns a function that passes the records one by one to the given function, stopping at the first error,
+// so that they can be written as they are produced.
+type exportSource func(ctx context.Context, s Store, q *exportQuery) (func(emit func(exportRecord) error) error, error)
+
+// memberHeader is the header of exported members.
+var memberHeader = []string{"BSID", "Name", "Email", "RFID", "Plan", "Expiration", "Effective Expiration", "Credits", "Debt", "Frozen"}
+
+// exportedMembers reads the members selected by the given export query, sorted by BSID.
+func exportedMembers(ctx context.Context, s Store, q *exportQuery) (func(emit func(exportRecord) error) error, error) {
+	ros, err := s.Roster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the members: %v", err)
+	}
+	now := time.Now()
+	members := ros.members(now)
+	sort.Slice(members, func(i, j int) bool { return members[i].BSID < members[j].BSID })
+	return func(emit func(exportRecord) error) error {
+		for _, u := range members {
+			if !q.within(u.EffectiveExpiration) || !hasAnyStatus(u, q.statuses, q.policy, now) {
+				continue
+			}
+			var credits string
+			if u.Credits != nil {
+				credits = strconv.Itoa(*u.Credits)
+			}
+			if err := emit(exportRecord{u, []string{
+				u.BSID,
+				u.Name,
+				u.Email,
+				u.ID,
+				u.Plan,
+				u.Expiration.In(loc).Format(queryDateFormat),
+				u.EffectiveExpiration.In(loc).Format(queryDateFormat),
+				credits,
+				strconv.FormatBool(u.Debt),
+				strconv.FormatBool(u.Frozen),
+			}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// exportedVisit is a visit along with the name of the member who visited.
+type exportedVisit struct {
+	visit
+	Name string `json:"name,omitempty"`
+}
+
+// visitHeader is the header of exported visits.
+var visitHeader = []string{"Time", "BSID", "Name", "Pass"}
+
+// exportedVisits reads the visits selected by the given export query in the order in which they were recorded.
+// If statuses are given, only the visits of current members in any of them are selected.
+func exportedVisits(ctx context.Context, s Store, q *exportQuery) (func(emit func(exportRecord) error) error, error) {
+	ros, err := s.Roster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the members: %v", err)
+	}
+	visits, err := s.Visits(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the visits: %v", err)
+	}
+	now := time.Now()
+	members := make(map[string]*user)
+	for _, u := range ros.members(now) {
+		members[u.BSID] = u
+	}
+	return func(emit func(exportRecord) error) error {
+		for _, v := range visits {
+			if !q.within(v.Time) {
+				continue
+			}
+			u := members[v.BSID]
+			if len(q.statuses) != 0 && (u == nil || !hasAnyStatus(u, q.statuses, q.policy, now)) {
+				continue
+			}
+			e := exportedVisit{visit: v}
+			if u != nil {
+				e.Name = u.Name
+			}
+			var pass string
+			if v.Pass != 0 {
+				pass = strconv.Itoa(v.Pass)
+			}
+			if err := emit(exportRecord{e, []string{v.Time.In(loc).Format(time.RFC3339), v.BSID, e.Name, pass}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// writeExport writes the records that the given function produces with the given header in the given format.
+// Records are written as they are produced; XLSX workbooks are only finished once all of them are written.
+// The name titles the worksheet of XLSX exports.
+func writeExport(w io.Writer, f ExportFormat, name string, header []string, records func(emit func(exportRecord) error) error) error {
+	switch f {
+	case ExportJSONLines:
+		e := json.NewEncoder(w)
+		return records(func(r exportRecord) error {
+			return e.Encode(r.value)
+		})
+	case ExportXLSX:
+		x, err := newXLSXWriter(w, name)
+		if err != nil {
+			return err
+		}
+		if err := x.Write(header); err != nil {
+			return err
+		}
+		if err := records(func(r exportRecord) error {
+			return x.Write(r.cells)
+		}); err != nil {
+			return err
+		}
+		return x.Close()
+	}
+	c := csv.NewWriter(w)
+	if err := c.Write(header); err != nil {
+		return err
+	}
+	// The CSV writer sends the records whenever its buffer fills up.
+	if err := records(func(r exportRecord) error {
+		return c.Write(r.cells)
+	}); err != nil {
+		return err
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// export writes the records of the given store that the given source selects with the given options to the given writer.
+func export(ctx context.Context, s Store, w io.Writer, o ExportOptions, name string, header []string, source exportSource) error {
+	q, err := newExportQuery(o)
+	if err != nil {
+		return err
+	}
+	records, err := source(ctx, s, q)
+	if err != nil {
+		return err
+	}
+	return writeExport(w, q.format, name, header, records)
+}
+
+// ExportMembers writes the members of the given store that are selected by the given options to the given writer.
+func ExportMembers(ctx context.Context, s Store, w io.Writer, o ExportOptions) error {
+	return export(ctx, s, w, o, "Members", memberHeader, exportedMembers)
+}
+
+// ExportVisits writes the visits of the given store that are selected by the given options to the given writer.
+func ExportVisits(ctx context.Context, s Store, w io.Writer, o ExportOptions) error {
+	return export(ctx, s, w, o, "Visits", visitHeader, exportedVisits)
+}
+
+// exportHandler allows the client to download the records that the given function selects, e.g. the members or the visits.
+// The format, status, from, and to query parameters select the format and the records of the export.
+func (a *API) exportHandler(name string, header []string, source exportSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := ExportOptions{Format: ExportFormat(strings.ToLower(r.URL.Query().Get("format"))), SoonDays: a.policy.soonDays}
+		if s := r.URL.Query().Get("status"); s != "" {
+			o.Statuses = strings.Split(s, ",")
+		}
+		var err error
+		if o.From, err = queryDate(r, "from"); err != nil {
+			writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+		if o.To, err = queryDate(r, "to"); err != nil {
+			writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+		q, err := newExportQuery(o)
+		if err != nil {
+			writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+		s, err := a.storeFromSession(r)
+		if err != nil {
+			writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+		records, err := source(r.Context(), s, q)
+		if err != nil {
+			log.Errorf("failed to export %s: %v", strings.ToLower(name), err)
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", q.format.contentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%s.%s", strings.ToLower(name), time.Now().In(loc).Format(queryDateFormat), q.format)))
+		if err := writeExport(w, q.format, name, header, records); err != nil {
+			log.Errorf("failed to write export of %s: %v", strings.ToLower(name), err)
+		}
+	})
+}
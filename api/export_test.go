@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExportMembers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		format   ExportFormat
+		statuses []string
+		// lines are the expected first fields of the lines of the export.
+		lines []string
+	}{
+		{
+			name:   "csv",
+			format: ExportCSV,
+			lines:  []string{"BSID", "a", "b"},
+		},
+		{
+			name:     "csv with statuses",
+			format:   ExportCSV,
+			statuses: []string{"debt"},
+			lines:    []string{"BSID"},
+		},
+		{
+			name:   "jsonl",
+			format: ExportJSONLines,
+			lines:  []string{`{"bsID":"a"`, `{"bsID":"b"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStore(t, user{BSID: "b", Name: "B"}, user{BSID: "a", Name: "A"})
+			var buf bytes.Buffer
+			if err := ExportMembers(context.Background(), s, &buf, ExportOptions{Format: tc.format, Statuses: tc.statuses}); err != nil {
+				t.Fatalf("failed to export members: %v", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(lines) != len(tc.lines) {
+				t.Fatalf("expected %d lines, got %d: %q", len(tc.lines), len(lines), buf.String())
+			}
+			for i := range lines {
+				if !strings.HasPrefix(lines[i], tc.lines[i]) {
+					t.Errorf("expected line %d to start with %q, got %q", i, tc.lines[i], lines[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// freeze is a period during which the membership of a member is paused.
+type freeze struct {
+	// ID identifies the freeze; for Google Sheets, it is the row of the freeze.
+	ID    int       `json:"id"`
+	BSID  string    `json:"bsID"`
+	Start time.Time `json:"start"`
+	// End is the day on which the membership resumes; it is zero while the freeze is open-ended.
+	End time.Time `json:"end"`
+}
+
+// active returns true if the membership is paused at the given time.
+func (f *freeze) active(now time.Time) bool {
+	return !now.Before(f.Start) && (f.End.IsZero() || now.Before(f.End))
+}
+
+// overlaps returns true if the given freeze covers any of the same days.
+func (f *freeze) overlaps(o *freeze) bool {
+	return (f.End.IsZero() || o.Start.Before(f.End)) && (o.End.IsZero() || f.Start.Before(o.End))
+}
+
+// endAt ends the freeze on the given day, unless it has already ended.
+// A freeze that has not started yet ends as soon as it starts, i.e. it is cancelled.
+func (f *freeze) endAt(t time.Time) {
+	if !f.End.IsZero() && f.End.Before(t) {
+		return
+	}
+	if t.Before(f.Start) {
+		t = f.Start
+	}
+	f.End = t
+}
+
+// setFreezes populates the freeze fields of the given user with the given freezes.
+// Every freeze that starts before the membership expires extends the membership by its duration;
+// open-ended freezes extend it until the given time.
+func setFreezes(u *user, freezes []freeze, now time.Time) {
+	u.Freezes = freezes
+	u.Frozen = false
+	u.EffectiveExpiration = u.Expiration
+	sorted := make([]freeze, len(freezes))
+	copy(sorted, freezes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	today := midnight(now)
+	for i := range sorted {
+		f := &sorted[i]
+		if f.active(now) {
+			u.Frozen = true
+		}
+		if f.Start.After(u.EffectiveExpiration) || f.Start.After(today) {
+			continue
+		}
+		end := f.End
+		if end.IsZero() || end.After(today) {
+			end = today
+		}
+		u.EffectiveExpiration = u.EffectiveExpiration.AddDate(0, 0, days(f.Start, end))
+	}
+}
+
+// midnight returns the start of the day of the given time in Berlin.
+func midnight(t time.Time) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// days returns the number of calendar days between the given times.
+func days(start, end time.Time) int {
+	s, e := midnight(start), midnight(end)
+	return int(math.Round(e.Sub(s).Hours() / 24))
+}
+
+// rowToFreeze converts a row of the freeze sheet to a freeze struct.
+func rowToFreeze(row []interface{}, id int) (*freeze, error) {
+	cells := make([]string, 3)
+	for i := range row {
+		if i >= len(cells) {
+			break
+		}
+		v, ok := row[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse freeze field %d", i+1)
+		}
+		cells[i] = strings.TrimSpace(v)
+	}
+	if cells[0] == "" {
+		return nil, errors.New("the freeze has no BSID")
+	}
+	f := &freeze{ID: id, BSID: strings.ToLower(cells[0])}
+	var err error
+	if f.Start, err = time.ParseInLocation(dateFormat, cells[1], loc); err != nil {
+		return nil, fmt.Errorf("failed to parse freeze start as date %v", err)
+	}
+	if cells[2] != "" {
+		if f.End, err = time.ParseInLocation(dateFormat, cells[2], loc); err != nil {
+			return nil, fmt.Errorf("failed to parse freeze end as date %v", err)
+		}
+	}
+	return f, nil
+}
+
+func freezeToRow(f *freeze) []interface{} {
+	end := ""
+	if !f.End.IsZero() {
+		end = f.End.Format(dateFormat)
+	}
+	return []interface{}{strings.ToLower(f.BSID), f.Start.Format(dateFormat), end}
+}
+
+// getFreezesHandler allows the client to list the freezes of a user.
+func (a *API) getFreezesHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	freezes, err := s.Freezes(r.Context(), bsID)
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if freezes == nil {
+		freezes = []freeze{}
+	}
+	writeJSON(freezes).ServeHTTP(w, r)
+}
+
+// addFreezeHandler allows the client to pause the membership of a user.
+// The freeze starts today unless a start is given and is open-ended unless an end is given.
+func (a *API) addFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	var req struct {
+		Start *time.Time `json:"start"`
+		End   *time.Time `json:"end"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	defer r.Body.Close()
+	f := freeze{BSID: strings.ToLower(bsID), Start: midnight(time.Now())}
+	if req.Start != nil {
+		f.Start = midnight(*req.Start)
+	}
+	if req.End != nil {
+		f.End = midnight(*req.End)
+		if !f.End.After(f.Start) {
+			writeJSONError(errors.New("the freeze must end after it starts"), http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+	}
+	if _, err := s.UserByBSID(r.Context(), bsID); err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	freezes, err := s.Freezes(r.Context(), bsID)
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	for i := range freezes {
+		if freezes[i].overlaps(&f) {
+			writeJSONError(fmt.Errorf("the freeze overlaps freeze %d", freezes[i].ID), http.StatusConflict).ServeHTTP(w, r)
+			return
+		}
+	}
+	if err := s.AddFreeze(r.Context(), &f); err != nil {
+		log.Errorf("failed to add freeze: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(f).ServeHTTP(w, r)
+}
+
+// endFreezeHandler allows the client to resume the membership of a user today.
+func (a *API) endFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	id, err := strconv.Atoi(mux.Vars(r)["freeze"])
+	if err != nil {
+		writeJSONError(fmt.Errorf("%q is not a valid freeze ID", mux.Vars(r)["freeze"]), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if err := s.EndFreeze(r.Context(), bsID, id, midnight(time.Now())); err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		log.Errorf("failed to end freeze: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	u, err := findUser(r.Context(), s, bsID, false)
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(u).ServeHTTP(w, r)
+}
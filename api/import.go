@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// csvTab is the tab under which problems with the rows of an imported CSV file are reported.
+	csvTab = "CSV"
+	// previewSize is the number of parsed members that an import report shows.
+	previewSize = 5
+	// maxImportSize is the largest CSV file that can be uploaded to the import endpoint.
+	maxImportSize = 10 << 20
+)
+
+// importDateFormats are the formats in which expirations are accepted in imported files.
+var importDateFormats = []string{dateFormat, queryDateFormat, time.RFC3339}
+
+// importRow is a member parsed from the row with the given 1-indexed number of an imported file.
+type importRow struct {
+	n int
+	u *user
+}
+
+// ImportReport describes the outcome of an import of members.
+type ImportReport struct {
+	// Columns maps each member field to the header of the column from which it is read.
+	Columns map[string]string `json:"columns"`
+	// Preview holds the first few valid members as they were parsed.
+	Preview []*user `json:"preview"`
+	// Valid is the number of rows that can be imported.
+	Valid int `json:"valid"`
+	// Imported is the number of members that were added; it is zero for dry runs.
+	Imported int `json:"imported"`
+	// Problems lists the rows that cannot be imported.
+	Problems []Problem `json:"problems"`
+}
+
+// ImportMembers reads members from the given CSV file, whose first row is the header, and adds the valid ones
+// to the given store in a single batch. The mapping overrides the default headers of the member columns.
+// Rows are validated like the members that clients create; rows that are invalid or that conflict with
+// each other or with existing or archived members are reported and skipped.
+// If dryRun is true, nothing is added.
+func ImportMembers(ctx context.Context, s Store, r io.Reader, mapping map[string]string, dryRun bool) (*ImportReport, error) {
+	report, rows, err := parseMembers(r, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return report, importMembers(ctx, s, report, rows, dryRun)
+}
+
+// parseMembers parses the members in the given CSV file and reports the rows that are invalid or duplicated within the file.
+func parseMembers(r io.Reader, mapping map[string]string) (*ImportReport, []importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, fmt.Errorf("the file has no header row")
+	}
+	header := make([]interface{}, len(records[0]))
+	for i := range records[0] {
+		header[i] = records[0][i]
+	}
+	cols, err := newColumns(header, mapping)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &ImportReport{Columns: make(map[string]string), Preview: []*user{}, Problems: []Problem{}}
+	for f, i := range cols.index {
+		report.Columns[string(f)] = records[0][i]
+	}
+	seen := map[field]map[string]int{bsIDField: {}, emailField: {}, rfidField: {}}
+	var rows []importRow
+	for i, record := range records[1:] {
+		// The first row is the header.
+		n := i + 2
+		row := make([]interface{}, len(record))
+		for j := range record {
+			row[j] = record[j]
+		}
+		if isEmptyRow(row) {
+			continue
+		}
+		u, err := recordToUser(row, cols)
+		if err != nil {
+			report.Problems = append(report.Problems, Problem{Tab: csvTab, Row: n, Reason: err.Error()})
+			continue
+		}
+		var duplicate bool
+		for _, d := range []struct {
+			f     field
+			value string
+		}{{bsIDField, u.BSID}, {emailField, u.Email}, {rfidField, u.ID}} {
+			if d.value == "" {
+				continue
+			}
+			if first, ok := seen[d.f][d.value]; ok {
+				report.Problems = append(report.Problems, Problem{Tab: csvTab, Row: n, Reason: fmt.Sprintf("the %s %q is already used on row %d", d.f, d.value, first)})
+				duplicate = true
+				continue
+			}
+			seen[d.f][d.value] = n
+		}
+		if duplicate {
+			continue
+		}
+		rows = append(rows, importRow{n, u})
+	}
+	return report, rows, nil
+}
+
+// recordToUser converts a row of an imported file to a user struct.
+// The values are validated by user.UnmarshalJSON, just like the members that clients create.
+func recordToUser(row []interface{}, cols *columns) (*user, error) {
+	m := make(map[string]interface{})
+	for _, f := range fields {
+		v, err := cols.get(row, f)
+		if err != nil {
+			return nil, err
+		}
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		switch f {
+		case bsIDField:
+			m["bsID"] = v
+		case rfidField:
+			m["id"] = v
+		case creditsField:
+			c, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("credits must be a non-negative integer, got %q", v)
+			}
+			m["credits"] = c
+		case expirationField:
+			t, err := parseImportDate(v)
+			if err != nil {
+				return nil, err
+			}
+			m["expiration"] = t.Format(time.RFC3339)
+		default:
+			m[string(f)] = v
+		}
+	}
+	for _, f := range requiredFields {
+		if v, _ := cols.get(row, f); strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("the %s is missing", f)
+		}
+	}
+	j, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var u user
+	if err := json.Unmarshal(j, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// parseImportDate parses a date in any of the import date formats.
+func parseImportDate(v string) (time.Time, error) {
+	for _, f := range importDateFormats {
+		if t, err := time.ParseInLocation(f, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("the expiration %q is not a date of the form DD/MM/YYYY or YYYY-MM-DD", v)
+}
+
+// importMembers reports the given members that conflict with existing members and,
+// unless dryRun is true, adds the others to the given store in a single batch.
+func importMembers(ctx context.Context, s Store, report *ImportReport, rows []importRow, dryRun bool) error {
+	taken, err := newTakenIDs(ctx, s, true)
+	if err != nil {
+		return err
+	}
+	var valid []*user
+	for _, row := range rows {
+		if err := taken.check(row.u, ""); err != nil {
+			report.Problems = append(report.Problems, Problem{Tab: csvTab, Row: row.n, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, row.u)
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool { return report.Problems[i].Row < report.Problems[j].Row })
+	report.Valid = len(valid)
+	if len(valid) > 0 {
+		report.Preview = valid[:min(len(valid), previewSize)]
+	}
+	if dryRun || len(valid) == 0 {
+		return nil
+	}
+	if err := s.CreateUsers(ctx, valid); err != nil {
+		return fmt.Errorf("failed to add the members: %v", err)
+	}
+	report.Imported = len(valid)
+	// Recycled cards must name their new owners in the history, or they will still be refused.
+	now := midnight(time.Now())
+	for _, u := range valid {
+		if _, ok := taken.active[u.ID]; u.ID == "" || !ok {
+			continue
+		}
+		if err := s.AddCard(ctx, &card{BSID: u.BSID, RFID: u.ID, Status: cardActive, Changed: now}); err != nil {
+			log.Errorf("failed to add card to the history of user %q: %v", u.BSID, err)
+		}
+	}
+	return nil
+}
+
+// importCSVHandler allows the client to add members from a CSV file in the request body.
+// The mapping query parameters, of the form field=header, override the default headers of the member columns.
+// If the dry_run query parameter is true, the file is only validated.
+func (a *API) importCSVHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	mapping := make(map[string]string)
+	for _, m := range r.URL.Query()["mapping"] {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 {
+			writeJSONError(fmt.Errorf("%q is not a valid mapping; expected field=header", m), http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	var dryRun bool
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeJSONError(fmt.Errorf("%q is not a valid dry_run", v), http.StatusBadRequest).ServeHTTP(w, r)
+			return
+		}
+	}
+	defer r.Body.Close()
+	report, rows, err := parseMembers(http.MaxBytesReader(w, r.Body, maxImportSize), mapping)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if err := importMembers(r.Context(), s, report, rows, dryRun); err != nil {
+		log.Errorf("failed to import members: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(report).ServeHTTP(w, r)
+}
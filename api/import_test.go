@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportMembers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		row      string
+		imported int
+		problem  string
+	}{
+		{
+			name:     "new member",
+			row:      "n,01/01/2030,New,n@example.com,c9",
+			imported: 1,
+		},
+		{
+			name:    "existing BSID",
+			row:     "a,01/01/2030,New,n@example.com,c9",
+			problem: `BSID "a" already belongs to member "a"`,
+		},
+		{
+			name:    "archived BSID",
+			row:     "old,01/01/2030,New,n@example.com,c9",
+			problem: `BSID "old" already belongs to member "old"`,
+		},
+		{
+			name:    "existing card",
+			row:     "n,01/01/2030,New,n@example.com,C1",
+			problem: `card "c1" already belongs to member "a"`,
+		},
+		{
+			name:     "retired card",
+			row:      "n,01/01/2030,New,n@example.com,r1",
+			imported: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, user{BSID: "a", ID: "c1"}, user{BSID: "old", ID: "r1"})
+			if err := s.AddCard(ctx, &card{BSID: "old", RFID: "r1", Status: cardRetired, Changed: midnight(time.Now())}); err != nil {
+				t.Fatalf("failed to add card: %v", err)
+			}
+			if err := s.UpdateUser(ctx, "old", &user{ID: "r0"}); err != nil {
+				t.Fatalf("failed to update user: %v", err)
+			}
+			if err := s.ArchiveUser(ctx, "old"); err != nil {
+				t.Fatalf("failed to archive user: %v", err)
+			}
+			report, err := ImportMembers(ctx, s, strings.NewReader("BSID,Expiration,Name,Email,RFID\n"+tc.row+"\n"), nil, false)
+			if err != nil {
+				t.Fatalf("failed to import members: %v", err)
+			}
+			if report.Imported != tc.imported {
+				t.Errorf("expected %d imported members, got %d", tc.imported, report.Imported)
+			}
+			var problems []string
+			for _, p := range report.Problems {
+				problems = append(problems, p.Reason)
+			}
+			if tc.problem == "" && len(problems) != 0 || tc.problem != "" && (len(problems) != 1 || problems[0] != tc.problem) {
+				t.Errorf("expected problem %q, got %q", tc.problem, problems)
+			}
+			if tc.imported == 0 {
+				return
+			}
+			u, _, err := findCardholder(ctx, s, strings.Split(tc.row, ",")[4])
+			if err != nil {
+				t.Fatalf("failed to find cardholder: %v", err)
+			}
+			if u == nil || u.BSID != "n" {
+				t.Errorf("expected the card to belong to %q, got %v", "n", u)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// expiringWindows are the numbers of days for which the memberships that expire within them are counted.
+var expiringWindows = []int{7, 30}
+
+// kpiStatuses are the states of memberships that are counted.
+var kpiStatuses = []memberStatus{memberActive, memberDebt, memberExpired, memberFrozen}
+
+// updateMemberMetrics counts the given members of the sheet with the given ID by status and by days until expiration.
+func (a *API) updateMemberMetrics(sid string, users []*user, now time.Time) {
+	for _, s := range kpiStatuses {
+		var n int
+		for _, u := range users {
+			if hasStatus(u, s, a.policy, now) {
+				n++
+			}
+		}
+		a.members.WithLabelValues(sid, string(s)).Set(float64(n))
+	}
+	for _, d := range expiringWindows {
+		var n int
+		for _, u := range users {
+			if now.Before(u.EffectiveExpiration) && expiresIn(u, now) <= d {
+				n++
+			}
+		}
+		a.membersExpiring.WithLabelValues(sid, strconv.Itoa(d)).Set(float64(n))
+	}
+}
+
+// refreshMemberMetrics counts the members of the sheets in use
+// so that the state of the memberships can be monitored.
+func (a *API) refreshMemberMetrics() {
+	now := time.Now()
+	stores := make(map[string]Store)
+	if a.config.Store != nil {
+		stores[localSheetID] = a.config.Store
+	} else {
+		for id, sid := range a.sessionSheets() {
+			stores[sid] = a.store(id, sid)
+		}
+	}
+	for sid, s := range stores {
+		r, err := s.Roster(context.Background())
+		if err != nil {
+			log.Warnf("failed to count members: %v", err)
+			continue
+		}
+		a.updateMemberMetrics(sid, r.members(now), now)
+	}
+}
+
+// watchMemberMetrics periodically refreshes the member metrics.
+func (a *API) watchMemberMetrics() {
+	if a.config.MetricsRefresh <= 0 {
+		return
+	}
+	a.refreshMemberMetrics()
+	ticker := time.NewTicker(a.config.MetricsRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+		a.refreshMemberMetrics()
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	memberTab  = "members"
+	archiveTab = "ARCHIVE"
+	cardTab    = "CARD"
+	debtTab    = "DEBT"
+	freezeTab  = "FREEZE"
+	passTab    = "PASS"
+	planTab    = "PLAN"
+	visitTab   = "VISIT"
+)
+
+// sheetTabs are the tabs of the membership spreadsheet besides the member tab, which is always the first tab.
+// A missing tab is read as empty and added when its first row is written, so lint only reports it.
+var sheetTabs = []string{archiveTab, cardTab, debtTab, freezeTab, passTab, planTab, visitTab}
+
+// Problem describes a row of a membership spreadsheet or of an imported file that is invalid.
+type Problem struct {
+	// Tab is the tab of the spreadsheet that holds the row.
+	Tab string `json:"tab"`
+	// Row is the 1-indexed number of the row in the tab, or 0 if the problem concerns the whole tab.
+	Row int `json:"row"`
+	// Reason explains what is wrong with the row.
+	Reason string `json:"reason"`
+}
+
+// String implements the fmt.Stringer interface.
+func (p Problem) String() string {
+	if p.Row == 0 {
+		return fmt.Sprintf("%s: %s", p.Tab, p.Reason)
+	}
+	return fmt.Sprintf("%s row %d: %s", p.Tab, p.Row, p.Reason)
+}
+
+// LintSheet reads the membership spreadsheet with the given ID and reports every missing tab
+// and every row that would fail to parse or that conflicts with other rows.
+// The headers override the default headers of the member columns.
+func LintSheet(ctx context.Context, oauthClient *http.Client, sid string, headers map[string]string) ([]Problem, error) {
+	c, err := newClient(oauthClient)
+	if err != nil {
+		return nil, err
+	}
+	present, err := getTabs(ctx, *c, sid)
+	if err != nil {
+		return nil, err
+	}
+	res, err := getRanges(ctx, *c, sid, userRange, debtRange, visitRange, freezeRange, passRange, cardRange, archiveRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(res.ValueRanges[0], headers)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for _, t := range sheetTabs {
+		if _, ok := present[t]; !ok {
+			problems = append(problems, Problem{Tab: t, Reason: "the spreadsheet has no tab with this name"})
+		}
+	}
+	// The rows of archived members may still refer to them.
+	var archived []string
+	if archive := res.ValueRanges[6]; len(archive.Values) != 0 {
+		acols, err := userRangeToColumns(archive, headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the archive: %v", err)
+		}
+		for _, row := range archive.Values[1:] {
+			if bsID, err := acols.get(row, bsIDField); err == nil && bsID != "" {
+				archived = append(archived, bsID)
+			}
+		}
+	}
+	return append(problems, lint(cols, res.ValueRanges[0].Values[1:], res.ValueRanges[1].Values, res.ValueRanges[2].Values, res.ValueRanges[3].Values, res.ValueRanges[4].Values, res.ValueRanges[5].Values, archived)...), nil
+}
+
+// lint checks the given member, debt, visit, freeze, pass, and card rows; the member rows must not include the header.
+// Rows may refer to the given BSIDs of archived members.
+func lint(cols *columns, users, debts, visits, freezes, passes, cards [][]interface{}, archived []string) []Problem {
+	var problems []Problem
+	report := func(tab string, row int, format string, a ...interface{}) {
+		problems = append(problems, Problem{Tab: tab, Row: row, Reason: fmt.Sprintf(format, a...)})
+	}
+	bsIDs := make(map[string]int)
+	emails := make(map[string]int)
+	rfids := make(map[string]int)
+	for i, row := range users {
+		// The Sheets API is not 0-index and the first row is the header.
+		n := i + 2
+		if isEmptyRow(row) {
+			continue
+		}
+		for _, f := range fields {
+			if c, ok := cols.index[f]; ok && c < len(row) {
+				if _, ok := row[c].(string); !ok {
+					report(memberTab, n, "the %s cell is not a string", f)
+				}
+			}
+		}
+		for _, f := range requiredFields {
+			if v, _ := cols.get(row, f); strings.TrimSpace(v) == "" {
+				report(memberTab, n, "the %s is missing", f)
+			}
+		}
+		if e, err := cols.get(row, expirationField); err == nil && e != "" {
+			if _, err := time.ParseInLocation(dateFormat, e, loc); err != nil {
+				report(memberTab, n, "the expiration %q is not a date of the form DD/MM/YYYY", e)
+			}
+		}
+		if c, err := cols.get(row, creditsField); err == nil {
+			if _, err := parseCredits(c); err != nil {
+				report(memberTab, n, "the credits %q are not a non-negative number", c)
+			}
+		}
+		if bsID, err := cols.get(row, bsIDField); err == nil && bsID != "" {
+			bsID = strings.ToLower(bsID)
+			if first, ok := bsIDs[bsID]; ok {
+				report(memberTab, n, "the BSID %q is already used on row %d", bsID, first)
+			} else {
+				bsIDs[bsID] = n
+			}
+		}
+		if email, err := cols.get(row, emailField); err == nil && strings.TrimSpace(email) != "" {
+			email = strings.ToLower(strings.TrimSpace(email))
+			if first, ok := emails[email]; ok {
+				report(memberTab, n, "the email %q is already used on row %d", email, first)
+			} else {
+				emails[email] = n
+			}
+		}
+		if id, err := cols.get(row, rfidField); err == nil && id != "" {
+			id = strings.ToLower(id)
+			if first, ok := rfids[id]; ok {
+				report(memberTab, n, "the RFID %q is already assigned to the member on row %d", id, first)
+			} else {
+				rfids[id] = n
+			}
+		}
+	}
+	isArchived := make(map[string]bool)
+	for _, bsID := range archived {
+		isArchived[strings.ToLower(strings.TrimSpace(bsID))] = true
+	}
+	passIDs := make(map[int]struct{})
+	for i, row := range passes {
+		if isEmptyRow(row) {
+			continue
+		}
+		p, err := rowToPass(row, i+1)
+		if err != nil {
+			report(passTab, i+1, "%v", err)
+			continue
+		}
+		passIDs[p.ID] = struct{}{}
+		if _, ok := bsIDs[p.Host]; p.Host != "" && !ok {
+			report(passTab, i+1, "the host %q is not a member", p.Host)
+		}
+	}
+	for _, r := range []struct {
+		name string
+		rows [][]interface{}
+	}{{debtTab, debts}, {visitTab, visits}, {freezeTab, freezes}, {cardTab, cards}} {
+		for i, row := range r.rows {
+			if isEmptyRow(row) {
+				continue
+			}
+			bsID, ok := row[0].(string)
+			if !ok {
+				report(r.name, i+1, "the BSID cell is not a string")
+				continue
+			}
+			if r.name == visitTab && bsID == "" {
+				v, err := rowToVisit(row)
+				if err != nil || v.Pass == 0 {
+					report(r.name, i+1, "the visit has neither a BSID nor a pass")
+				} else if _, ok := passIDs[v.Pass]; !ok {
+					report(r.name, i+1, "the pass %d does not exist", v.Pass)
+				}
+				continue
+			}
+			if _, ok := bsIDs[strings.ToLower(bsID)]; !ok && !isArchived[strings.ToLower(bsID)] {
+				report(r.name, i+1, "the BSID %q does not belong to any member", bsID)
+			}
+		}
+	}
+	for i, row := range freezes {
+		if isEmptyRow(row) {
+			continue
+		}
+		if _, err := rowToFreeze(row, i+1); err != nil {
+			report(freezeTab, i+1, "%v", err)
+		}
+	}
+	var history []card
+	for i, row := range cards {
+		if isEmptyRow(row) {
+			continue
+		}
+		c, err := rowToCard(row, i+1)
+		if err != nil {
+			report(cardTab, i+1, "%v", err)
+			continue
+		}
+		history = append(history, *c)
+	}
+	latest := latestCards(history)
+	for _, c := range history {
+		if latest[c.RFID].ID != c.ID || c.Status != cardActive {
+			continue
+		}
+		if n, ok := rfids[c.RFID]; ok {
+			if bsID, _ := cols.get(users[n-2], bsIDField); strings.ToLower(bsID) != c.BSID {
+				report(cardTab, c.ID, "the card %q is also assigned to the member on row %d", c.RFID, n)
+			}
+		}
+	}
+	return problems
+}
+
+// isEmptyRow returns true if the given row has no non-empty cells.
+func isEmptyRow(row []interface{}) bool {
+	for i := range row {
+		if v, ok := row[i].(string); !ok || strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
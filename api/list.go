@@ -0,0 +1,307 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// queryDateFormat is the format of dates in query parameters.
+const queryDateFormat = "2006-01-02"
+
+// memberStatus is a state of a membership by which members can be filtered.
+type memberStatus string
+
+const (
+	memberActive   memberStatus = "active"
+	memberDebt     memberStatus = "debt"
+	memberExpired  memberStatus = "expired"
+	memberExpiring memberStatus = "expiring"
+	memberFrozen   memberStatus = "frozen"
+)
+
+// hasStatus returns true if the membership of the given user is in the given state at the given time
+// according to the given policy.
+func hasStatus(u *user, s memberStatus, p *policy, now time.Time) bool {
+	switch s {
+	case memberActive:
+		return now.Before(u.EffectiveExpiration)
+	case memberDebt:
+		return u.Debt
+	case memberExpired:
+		return !now.Before(u.EffectiveExpiration)
+	case memberExpiring:
+		return now.Before(u.EffectiveExpiration) && expiresIn(u, now) <= p.soonDays
+	case memberFrozen:
+		return u.Frozen
+	}
+	return false
+}
+
+// listSort is a field by which members can be sorted.
+type listSort string
+
+const (
+	sortBSID       listSort = "bsid"
+	sortExpiration listSort = "expiration"
+	sortLastVisit  listSort = "last_visit"
+	sortName       listSort = "name"
+)
+
+// sortKey returns the value of the given user by which it is sorted; keys compare as strings.
+func sortKey(u *user, s listSort) string {
+	switch s {
+	case sortBSID:
+		return u.BSID
+	case sortExpiration:
+		return u.EffectiveExpiration.UTC().Format(time.RFC3339)
+	case sortLastVisit:
+		if u.LastVisit == nil {
+			return ""
+		}
+		return u.LastVisit.UTC().Format(time.RFC3339)
+	}
+	return fold(u.Name)
+}
+
+// listCursor marks the position after which the next page of a listing starts.
+// It is handed to clients as an opaque string.
+type listCursor struct {
+	Sort listSort `json:"s"`
+	Key  string   `json:"k"`
+	BSID string   `json:"id"`
+}
+
+func (c listCursor) String() string {
+	j, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(j)
+}
+
+func parseCursor(s string) (*listCursor, error) {
+	j, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("the cursor is not valid")
+	}
+	var c listCursor
+	if err := json.Unmarshal(j, &c); err != nil {
+		return nil, errors.New("the cursor is not valid")
+	}
+	return &c, nil
+}
+
+// listQuery holds the filters, sorting, and paging of a member listing.
+type listQuery struct {
+	statuses      []memberStatus
+	plan          string
+	visitedAfter  time.Time
+	visitedBefore time.Time
+	sort          listSort
+	descending    bool
+	limit         int
+	cursor        *listCursor
+}
+
+// needsVisits returns true if the listing cannot be answered without the visits of the members.
+func (q *listQuery) needsVisits() bool {
+	return !q.visitedAfter.IsZero() || !q.visitedBefore.IsZero() || q.sort == sortLastVisit
+}
+
+// queryDate parses the query parameter with the given name as a day in Berlin;
+// if it is not set, the zero time is returned.
+func queryDate(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(queryDateFormat, v, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a valid %s; expected a date of the form YYYY-MM-DD", v, name)
+	}
+	return t, nil
+}
+
+// parseStatuses parses the given member statuses; empty ones are ignored.
+func parseStatuses(statuses []string) ([]memberStatus, error) {
+	var parsed []memberStatus
+	for _, s := range statuses {
+		switch s := memberStatus(strings.ToLower(strings.TrimSpace(s))); s {
+		case "":
+		case memberActive, memberDebt, memberExpired, memberExpiring, memberFrozen:
+			parsed = append(parsed, s)
+		default:
+			return nil, fmt.Errorf("%q is not a valid status; expected one of active, debt, expired, expiring, or frozen", s)
+		}
+	}
+	return parsed, nil
+}
+
+// hasAnyStatus returns true if no statuses are given or if the membership of the given user is in any of them.
+func hasAnyStatus(u *user, statuses []memberStatus, p *policy, now time.Time) bool {
+	if len(statuses) == 0 {
+		return true
+	}
+	for _, s := range statuses {
+		if hasStatus(u, s, p, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseListQuery parses the query parameters of a member listing.
+func parseListQuery(r *http.Request) (*listQuery, error) {
+	v := r.URL.Query()
+	q := listQuery{plan: strings.TrimSpace(v.Get("plan")), sort: sortName}
+	var err error
+	if q.statuses, err = parseStatuses(strings.Split(v.Get("status"), ",")); err != nil {
+		return nil, err
+	}
+	if q.visitedAfter, err = queryDate(r, "visited_after"); err != nil {
+		return nil, err
+	}
+	if q.visitedBefore, err = queryDate(r, "visited_before"); err != nil {
+		return nil, err
+	}
+	if s := v.Get("sort"); s != "" {
+		q.descending = strings.HasPrefix(s, "-")
+		switch q.sort = listSort(strings.TrimPrefix(s, "-")); q.sort {
+		case sortBSID, sortExpiration, sortLastVisit, sortName:
+		default:
+			return nil, fmt.Errorf("%q is not a valid sort; expected one of bsid, expiration, last_visit, or name, optionally prefixed with - to sort in descending order", s)
+		}
+	}
+	if q.limit, err = pageSize(r); err != nil {
+		return nil, err
+	}
+	if c := v.Get("cursor"); c != "" {
+		if q.cursor, err = parseCursor(c); err != nil {
+			return nil, err
+		}
+		if q.cursor.Sort != q.sort {
+			return nil, errors.New("the cursor belongs to a listing with a different sort")
+		}
+	}
+	return &q, nil
+}
+
+// match returns true if the given user passes the filters of the listing.
+func (q *listQuery) match(u *user, p *policy, now time.Time) bool {
+	if q.plan != "" && !strings.EqualFold(u.Plan, q.plan) {
+		return false
+	}
+	if !hasAnyStatus(u, q.statuses, p, now) {
+		return false
+	}
+	// Members who never visited have not visited after any day but have not visited since any day, either.
+	if !q.visitedAfter.IsZero() && (u.LastVisit == nil || u.LastVisit.Before(q.visitedAfter)) {
+		return false
+	}
+	if !q.visitedBefore.IsZero() && u.LastVisit != nil && !u.LastVisit.Before(q.visitedBefore) {
+		return false
+	}
+	return true
+}
+
+// list filters and sorts the given users and returns the requested page along with the cursor of the next page, if any.
+func (q *listQuery) list(users []*user, p *policy, now time.Time) ([]*user, string) {
+	keys := make(map[*user]string)
+	var matched []*user
+	for _, u := range users {
+		if q.match(u, p, now) {
+			keys[u] = sortKey(u, q.sort)
+			matched = append(matched, u)
+		}
+	}
+	less := func(ki, idi, kj, idj string) bool {
+		switch {
+		case ki != kj:
+			return (ki < kj) != q.descending
+		case idi != idj:
+			return (idi < idj) != q.descending
+		}
+		return false
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return less(keys[matched[i]], matched[i].BSID, keys[matched[j]], matched[j].BSID)
+	})
+	start := 0
+	if q.cursor != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return less(q.cursor.Key, q.cursor.BSID, keys[matched[i]], matched[i].BSID)
+		})
+	}
+	end := min(len(matched), start+q.limit)
+	page := matched[start:end]
+	if end == len(matched) {
+		return page, ""
+	}
+	last := page[len(page)-1]
+	return page, listCursor{Sort: q.sort, Key: keys[last], BSID: last.BSID}.String()
+}
+
+// lastVisits returns the time of the most recent of the given visits for each BSID.
+func lastVisits(visits []visit) map[string]time.Time {
+	last := make(map[string]time.Time)
+	for _, v := range visits {
+		if v.BSID == "" {
+			continue
+		}
+		if t, ok := last[v.BSID]; !ok || v.Time.After(t) {
+			last[v.BSID] = v.Time
+		}
+	}
+	return last
+}
+
+// listUsersHandler allows the client to list the users, filtered by status, plan, and last visit.
+// The results are sorted by the sort query parameter and paged with the limit and cursor query parameters.
+func (a *API) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	ros, err := s.Roster(r.Context())
+	if err != nil {
+		log.Errorf("failed to list users: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	now := time.Now()
+	users := ros.members(now)
+	if q.needsVisits() {
+		visits, err := s.Visits(r.Context())
+		if err != nil {
+			log.Errorf("failed to list visits: %v", err)
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		last := lastVisits(visits)
+		for _, u := range users {
+			if t, ok := last[u.BSID]; ok {
+				u.LastVisit = &t
+			}
+		}
+	}
+	page, next := q.list(users, a.policy, now)
+	if page == nil {
+		page = []*user{}
+	}
+	writeJSON(struct {
+		Users []*user `json:"users"`
+		// Next is the cursor of the next page; it is empty on the last page.
+		Next string `json:"next,omitempty"`
+	}{page, next}).ServeHTTP(w, r)
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store that keeps all data in memory.
+// Nothing is persisted, so it is mostly useful for development.
+type memoryStore struct {
+	sync.Mutex
+	// archived holds the users who are no longer members, keyed by BSID.
+	archived map[string]user
+	cards    []card
+	debts    []debt
+	freezes  []freeze
+	passes   []pass
+	plans    []plan
+	users    map[string]user
+	visits   []visit
+}
+
+// NewMemoryStore returns a new Store that keeps all data in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		archived: make(map[string]user),
+		users:    make(map[string]user),
+	}
+}
+
+// UserByBSID implements the Store interface.
+func (m *memoryStore) UserByBSID(_ context.Context, bsID string) (*user, error) {
+	m.Lock()
+	defer m.Unlock()
+	u, ok := m.users[strings.ToLower(bsID)]
+	if !ok {
+		return nil, &notFoundError{"user", bsID}
+	}
+	return &u, nil
+}
+
+// Users implements the Store interface.
+func (m *memoryStore) Users(_ context.Context) ([]*user, error) {
+	m.Lock()
+	defer m.Unlock()
+	users := make([]*user, 0, len(m.users))
+	for _, u := range m.users {
+		u := u
+		users = append(users, &u)
+	}
+	return users, nil
+}
+
+// UserByRFID implements the Store interface.
+func (m *memoryStore) UserByRFID(_ context.Context, rfid string) (*user, error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, u := range m.users {
+		if u.ID != "" && u.ID == strings.ToLower(rfid) {
+			return &u, nil
+		}
+	}
+	return nil, &notFoundError{"user", rfid}
+}
+
+// UserByEmail implements the Store interface.
+func (m *memoryStore) UserByEmail(_ context.Context, email string) (*user, error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, u := range m.users {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			return &u, nil
+		}
+	}
+	return nil, &notFoundError{"user", email}
+}
+
+// CreateUser implements the Store interface.
+func (m *memoryStore) CreateUser(_ context.Context, u *user) error {
+	m.Lock()
+	defer m.Unlock()
+	bsID := strings.ToLower(u.BSID)
+	if _, ok := m.users[bsID]; ok {
+		return fmt.Errorf("user %q already exists", u.BSID)
+	}
+	m.users[bsID] = *u
+	return nil
+}
+
+// CreateUsers implements the Store interface.
+func (m *memoryStore) CreateUsers(_ context.Context, users []*user) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, u := range users {
+		if _, ok := m.users[strings.ToLower(u.BSID)]; ok {
+			return fmt.Errorf("user %q already exists", u.BSID)
+		}
+	}
+	for _, u := range users {
+		m.users[strings.ToLower(u.BSID)] = *u
+	}
+	return nil
+}
+
+// UpdateUser implements the Store interface.
+func (m *memoryStore) UpdateUser(_ context.Context, bsID string, u *user) error {
+	m.Lock()
+	defer m.Unlock()
+	bsID = strings.ToLower(bsID)
+	existing, ok := m.users[bsID]
+	if !ok {
+		return &notFoundError{"user", bsID}
+	}
+	mergeUser(&existing, u)
+	delete(m.users, bsID)
+	m.users[existing.BSID] = existing
+	return nil
+}
+
+// ArchiveUser implements the Store interface.
+func (m *memoryStore) ArchiveUser(_ context.Context, bsID string) error {
+	m.Lock()
+	defer m.Unlock()
+	bsID = strings.ToLower(bsID)
+	u, ok := m.users[bsID]
+	if !ok {
+		return &notFoundError{"user", bsID}
+	}
+	delete(m.users, bsID)
+	m.archived[bsID] = u
+	return nil
+}
+
+// ArchivedUsers implements the Store interface.
+func (m *memoryStore) ArchivedUsers(_ context.Context) ([]*user, error) {
+	m.Lock()
+	defer m.Unlock()
+	users := make([]*user, 0, len(m.archived))
+	for _, u := range m.archived {
+		u := u
+		users = append(users, &u)
+	}
+	return users, nil
+}
+
+// DeleteUser implements the Store interface.
+func (m *memoryStore) DeleteUser(_ context.Context, bsID string) (*user, error) {
+	m.Lock()
+	defer m.Unlock()
+	bsID = strings.ToLower(bsID)
+	u, ok := m.users[bsID]
+	if ok {
+		delete(m.users, bsID)
+	} else if u, ok = m.archived[bsID]; ok {
+		delete(m.archived, bsID)
+	} else {
+		return nil, &notFoundError{"user", bsID}
+	}
+	visits := m.visits[:0]
+	for _, v := range m.visits {
+		if v.BSID != bsID {
+			visits = append(visits, v)
+		}
+	}
+	m.visits = visits
+	cards := m.cards[:0]
+	for _, c := range m.cards {
+		if c.BSID != bsID {
+			cards = append(cards, c)
+		}
+	}
+	m.cards = cards
+	debts := m.debts[:0]
+	for _, d := range m.debts {
+		if d.BSID != bsID {
+			debts = append(debts, d)
+		}
+	}
+	m.debts = debts
+	freezes := m.freezes[:0]
+	for _, f := range m.freezes {
+		if f.BSID != bsID {
+			freezes = append(freezes, f)
+		}
+	}
+	m.freezes = freezes
+	return &u, nil
+}
+
+// ReassignCard implements the Store interface.
+func (m *memoryStore) ReassignCard(_ context.Context, rfid, bsID string) error {
+	m.Lock()
+	defer m.Unlock()
+	rfid, bsID = strings.ToLower(rfid), strings.ToLower(bsID)
+	u, ok := m.users[bsID]
+	if !ok {
+		return &notFoundError{"user", bsID}
+	}
+	for id, o := range m.users {
+		if id != bsID && o.ID == rfid {
+			o.ID = ""
+			m.users[id] = o
+		}
+	}
+	u.ID = rfid
+	m.users[bsID] = u
+	return nil
+}
+
+// Debts implements the Store interface.
+func (m *memoryStore) Debts(_ context.Context, bsID string) ([]debt, error) {
+	m.Lock()
+	defer m.Unlock()
+	var debts []debt
+	for _, d := range m.debts {
+		if d.BSID == strings.ToLower(bsID) {
+			debts = append(debts, d)
+		}
+	}
+	return debts, nil
+}
+
+// AddDebt implements the Store interface.
+func (m *memoryStore) AddDebt(_ context.Context, d *debt) error {
+	m.Lock()
+	defer m.Unlock()
+	d.ID = len(m.debts) + 1
+	m.debts = append(m.debts, *d)
+	return nil
+}
+
+// SettleDebt implements the Store interface.
+func (m *memoryStore) SettleDebt(_ context.Context, bsID string, id int) error {
+	m.Lock()
+	defer m.Unlock()
+	if id < 1 || id > len(m.debts) || m.debts[id-1].BSID != strings.ToLower(bsID) {
+		return &notFoundError{"debt", strconv.Itoa(id)}
+	}
+	m.debts[id-1].Settled = true
+	return nil
+}
+
+// Freezes implements the Store interface.
+func (m *memoryStore) Freezes(_ context.Context, bsID string) ([]freeze, error) {
+	m.Lock()
+	defer m.Unlock()
+	var freezes []freeze
+	for _, f := range m.freezes {
+		if f.BSID == strings.ToLower(bsID) {
+			freezes = append(freezes, f)
+		}
+	}
+	return freezes, nil
+}
+
+// AddFreeze implements the Store interface.
+func (m *memoryStore) AddFreeze(_ context.Context, f *freeze) error {
+	m.Lock()
+	defer m.Unlock()
+	f.ID = len(m.freezes) + 1
+	m.freezes = append(m.freezes, *f)
+	return nil
+}
+
+// EndFreeze implements the Store interface.
+func (m *memoryStore) EndFreeze(_ context.Context, bsID string, id int, end time.Time) error {
+	m.Lock()
+	defer m.Unlock()
+	if id < 1 || id > len(m.freezes) || m.freezes[id-1].BSID != strings.ToLower(bsID) {
+		return &notFoundError{"freeze", strconv.Itoa(id)}
+	}
+	m.freezes[id-1].endAt(end)
+	return nil
+}
+
+// Cards implements the Store interface.
+func (m *memoryStore) Cards(_ context.Context, bsID string) ([]card, error) {
+	m.Lock()
+	defer m.Unlock()
+	var cards []card
+	for _, c := range m.cards {
+		if c.BSID == strings.ToLower(bsID) {
+			cards = append(cards, c)
+		}
+	}
+	return cards, nil
+}
+
+// CardByRFID implements the Store interface.
+func (m *memoryStore) CardByRFID(_ context.Context, rfid string) (*card, error) {
+	m.Lock()
+	defer m.Unlock()
+	for i := len(m.cards) - 1; i >= 0; i-- {
+		if m.cards[i].RFID == strings.ToLower(rfid) {
+			c := m.cards[i]
+			return &c, nil
+		}
+	}
+	return nil, &notFoundError{"card", rfid}
+}
+
+// AddCard implements the Store interface.
+func (m *memoryStore) AddCard(_ context.Context, c *card) error {
+	m.Lock()
+	defer m.Unlock()
+	c.ID = len(m.cards) + 1
+	m.cards = append(m.cards, *c)
+	return nil
+}
+
+// Plans implements the Store interface.
+func (m *memoryStore) Plans(_ context.Context) ([]plan, error) {
+	m.Lock()
+	defer m.Unlock()
+	plans := make([]plan, len(m.plans))
+	copy(plans, m.plans)
+	return plans, nil
+}
+
+// CreatePlan implements the Store interface.
+func (m *memoryStore) CreatePlan(_ context.Context, p *plan) error {
+	m.Lock()
+	defer m.Unlock()
+	m.plans = append(m.plans, *p)
+	return nil
+}
+
+// Passes implements the Store interface.
+func (m *memoryStore) Passes(_ context.Context) ([]pass, error) {
+	m.Lock()
+	defer m.Unlock()
+	passes := make([]pass, len(m.passes))
+	copy(passes, m.passes)
+	return passes, nil
+}
+
+// CreatePass implements the Store interface.
+func (m *memoryStore) CreatePass(_ context.Context, p *pass) error {
+	m.Lock()
+	defer m.Unlock()
+	p.ID = 1
+	for i := range m.passes {
+		if m.passes[i].ID >= p.ID {
+			p.ID = m.passes[i].ID + 1
+		}
+	}
+	m.passes = append(m.passes, *p)
+	return nil
+}
+
+// Roster implements the Store interface.
+func (m *memoryStore) Roster(_ context.Context) (*roster, error) {
+	m.Lock()
+	defer m.Unlock()
+	users := make([]*user, 0, len(m.users))
+	for _, u := range m.users {
+		u := u
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].BSID < users[j].BSID })
+	cards := make(map[string][]card)
+	for _, c := range m.cards {
+		cards[c.BSID] = append(cards[c.BSID], c)
+	}
+	debts := make(map[string][]debt)
+	for _, d := range m.debts {
+		debts[d.BSID] = append(debts[d.BSID], d)
+	}
+	freezes := make(map[string][]freeze)
+	for _, f := range m.freezes {
+		freezes[f.BSID] = append(freezes[f.BSID], f)
+	}
+	return newRoster(users, cards, debts, freezes), nil
+}
+
+// Visits implements the Store interface.
+func (m *memoryStore) Visits(_ context.Context) ([]visit, error) {
+	m.Lock()
+	defer m.Unlock()
+	visits := make([]visit, len(m.visits))
+	copy(visits, m.visits)
+	return visits, nil
+}
+
+// RecordVisit implements the Store interface.
+func (m *memoryStore) RecordVisit(_ context.Context, v *visit) error {
+	m.Lock()
+	defer m.Unlock()
+	m.visits = append(m.visits, *v)
+	return nil
+}
+
+// load implements the loader interface.
+// The store must be empty, since the cards, debts, freezes, and visits of the snapshot are appended.
+func (m *memoryStore) load(_ context.Context, s *snapshot) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, r := range []struct {
+		name string
+		n    int
+	}{{"users", len(m.users)}, {"archive", len(m.archived)}, {"cards", len(m.cards)}, {"debts", len(m.debts)}, {"freezes", len(m.freezes)}, {"passes", len(m.passes)}, {"plans", len(m.plans)}, {"visits", len(m.visits)}} {
+		if r.n != 0 {
+			return &notEmptyError{r.name}
+		}
+	}
+	for _, u := range s.Users {
+		m.users[strings.ToLower(u.BSID)] = *u
+	}
+	for _, u := range s.Archived {
+		m.archived[strings.ToLower(u.BSID)] = *u
+	}
+	for _, c := range s.Cards {
+		c.ID = len(m.cards) + 1
+		m.cards = append(m.cards, c)
+	}
+	for _, d := range s.Debts {
+		d.ID = len(m.debts) + 1
+		m.debts = append(m.debts, d)
+	}
+	for _, f := range s.Freezes {
+		f.ID = len(m.freezes) + 1
+		m.freezes = append(m.freezes, f)
+	}
+	// Keep the IDs of passes so that the visits of their holders still refer to them.
+	m.passes = append(m.passes, s.Passes...)
+	m.plans = append(m.plans, s.Plans...)
+	m.visits = append(m.visits, s.Visits...)
+	return nil
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tapDebounce is the time during which a card that is read again is considered the same tap,
+// e.g. because the scan is handled once for every session.
+const tapDebounce = 5 * time.Second
+
+// occupancy tracks the cards of the people who are inside.
+type occupancy struct {
+	sync.Mutex
+	// capacity is the number of people who may be inside at once; zero means that there is no limit.
+	capacity int
+	// debounce is the time after checking in or out during which taps of the same card repeat the check-in or check-out.
+	debounce time.Duration
+	// toggle is true if tapping a card at the entrance while inside checks out, i.e. if there is no exit reader.
+	toggle bool
+	// window is the time after checking in after which people who did not check out are no longer counted.
+	window time.Duration
+	inside map[string]time.Time
+	left   map[string]time.Time
+}
+
+// newOccupancy returns an occupancy tracker; if the window is zero, nothing is tracked.
+func newOccupancy(capacity int, window, duplicate time.Duration, toggle bool) *occupancy {
+	debounce := duplicate
+	if debounce < tapDebounce {
+		debounce = tapDebounce
+	}
+	return &occupancy{
+		capacity: capacity,
+		debounce: debounce,
+		toggle:   toggle,
+		window:   window,
+		inside:   make(map[string]time.Time),
+		left:     make(map[string]time.Time),
+	}
+}
+
+// tracking returns true if occupancy is tracked.
+func (o *occupancy) tracking() bool {
+	return o.window > 0
+}
+
+// prune forgets the people who checked in too long ago and the check-outs that can no longer be repeated.
+// It must be called with the lock held.
+func (o *occupancy) prune(now time.Time) {
+	for c, t := range o.inside {
+		if now.Sub(t) >= o.window {
+			delete(o.inside, c)
+		}
+	}
+	for c, t := range o.left {
+		if now.Sub(t) >= o.debounce {
+			delete(o.left, c)
+		}
+	}
+}
+
+// count returns the number of people who are inside at the given time.
+func (o *occupancy) count(now time.Time) int {
+	if !o.tracking() {
+		return 0
+	}
+	o.Lock()
+	defer o.Unlock()
+	o.prune(now)
+	return len(o.inside)
+}
+
+// tapOut checks the holder of the given card out if the card was tapped at the entrance while inside
+// and returns true if it was. Only taps after the debounce time check out, so that repeats of a check-in do not.
+func (o *occupancy) tapOut(card string, now time.Time) bool {
+	if !o.tracking() || !o.toggle {
+		return false
+	}
+	o.Lock()
+	defer o.Unlock()
+	o.prune(now)
+	if _, ok := o.left[card]; ok {
+		return true
+	}
+	if t, ok := o.inside[card]; ok && now.Sub(t) >= o.debounce {
+		delete(o.inside, card)
+		o.left[card] = now
+		return true
+	}
+	return false
+}
+
+// checkOut checks the holder of the given card out, e.g. because it was tapped at the exit.
+func (o *occupancy) checkOut(card string, now time.Time) {
+	if !o.tracking() {
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	delete(o.inside, card)
+	o.left[card] = now
+}
+
+// full returns true if the holder of the given card is not inside and there is no room for them.
+func (o *occupancy) full(card string, now time.Time) bool {
+	if !o.tracking() || o.capacity <= 0 {
+		return false
+	}
+	o.Lock()
+	defer o.Unlock()
+	o.prune(now)
+	if _, ok := o.inside[card]; ok {
+		return false
+	}
+	return len(o.inside) >= o.capacity
+}
+
+// checkIn checks the holder of the given card in, unless they are already inside.
+func (o *occupancy) checkIn(card string, now time.Time) {
+	if !o.tracking() {
+		return
+	}
+	o.Lock()
+	defer o.Unlock()
+	if _, ok := o.inside[card]; !ok {
+		o.inside[card] = now
+	}
+}
+
+// admit checks the holder of the scanned card out if they tapped it while inside;
+// the decision of the policy is kept, so that staff still see why the holder would be refused.
+// Otherwise, they are refused if the gym is full and checked in if they may enter.
+// It returns true if a visit should be recorded.
+func (a *API) admit(e *scanEvent, scanID string, now time.Time) bool {
+	card := strings.ToLower(scanID)
+	if a.occupancy.tapOut(card, now) {
+		e.CheckOut = true
+		return false
+	}
+	if a.occupancy.full(card, now) {
+		e.Decision, e.Reasons = a.policy.add(e.Decision, e.Reasons, reasonGymFull)
+	}
+	if e.Decision == decisionDenied {
+		return false
+	}
+	a.occupancy.checkIn(card, now)
+	return !e.Duplicate
+}
+
+// watchExitRFID checks out the holders of the cards that are scanned by the exit scanner.
+func (a *API) watchExitRFID() {
+	if a.exitRFID == nil {
+		return
+	}
+	scan := a.exitRFID.Scan()
+	for {
+		select {
+		case <-a.done:
+			return
+		case r, ok := <-scan:
+			if !ok {
+				log.Info("Reached the end of exit RFID scanner input")
+				return
+			}
+			a.occupancy.checkOut(strings.ToLower(r), time.Now())
+		}
+	}
+}
+
+// occupancyHandler allows anyone to see how many people are inside, e.g. for a crowd meter on a website.
+func (a *API) occupancyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if !a.occupancy.tracking() {
+		writeJSONError(errors.New("occupancy is not tracked"), http.StatusNotFound).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(struct {
+		Count int `json:"count"`
+		// Capacity is the number of people who may be inside at once; it is omitted if there is no limit.
+		Capacity int `json:"capacity,omitempty"`
+	}{a.occupancy.count(time.Now()), a.occupancy.capacity}).ServeHTTP(w, r)
+}
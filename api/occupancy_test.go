@@ -0,0 +1,140 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmit(t *testing.T) {
+	start := time.Date(2020, time.March, 2, 18, 0, 0, 0, loc)
+	for _, tc := range []struct {
+		name     string
+		capacity int
+		window   time.Duration
+		// inside are the cards of the people who checked in at the start.
+		inside []string
+		// at is the time since the start at which the card "c1" is tapped.
+		at       time.Duration
+		decision decision
+		reasons  []reason
+		// The expected results.
+		record           bool
+		checkOut         bool
+		expectedDecision decision
+		expectedReasons  []reason
+		count            int
+	}{
+		{
+			name:             "not tracked",
+			at:               time.Hour,
+			decision:         decisionAllowed,
+			reasons:          []reason{},
+			record:           true,
+			expectedDecision: decisionAllowed,
+			expectedReasons:  []reason{},
+		},
+		{
+			name:             "check in",
+			window:           3 * time.Hour,
+			inside:           []string{"c2"},
+			decision:         decisionAllowed,
+			reasons:          []reason{},
+			record:           true,
+			expectedDecision: decisionAllowed,
+			expectedReasons:  []reason{},
+			count:            2,
+		},
+		{
+			name:             "denied",
+			window:           3 * time.Hour,
+			decision:         decisionDenied,
+			reasons:          []reason{reasonExpired},
+			expectedDecision: decisionDenied,
+			expectedReasons:  []reason{reasonExpired},
+		},
+		{
+			name:             "full",
+			capacity:         1,
+			window:           3 * time.Hour,
+			inside:           []string{"c2"},
+			decision:         decisionWarning,
+			reasons:          []reason{reasonExpiringSoon},
+			expectedDecision: decisionDenied,
+			expectedReasons:  []reason{reasonExpiringSoon, reasonGymFull},
+			count:            1,
+		},
+		{
+			name:             "full after the window",
+			capacity:         1,
+			window:           3 * time.Hour,
+			inside:           []string{"c2"},
+			at:               3 * time.Hour,
+			decision:         decisionAllowed,
+			reasons:          []reason{},
+			record:           true,
+			expectedDecision: decisionAllowed,
+			expectedReasons:  []reason{},
+			count:            1,
+		},
+		{
+			name:             "repeat of the check-in",
+			capacity:         1,
+			window:           3 * time.Hour,
+			inside:           []string{"c1"},
+			at:               time.Second,
+			decision:         decisionAllowed,
+			reasons:          []reason{},
+			record:           true,
+			expectedDecision: decisionAllowed,
+			expectedReasons:  []reason{},
+			count:            1,
+		},
+		{
+			name:             "check out",
+			window:           3 * time.Hour,
+			inside:           []string{"c1", "c2"},
+			at:               time.Hour,
+			decision:         decisionAllowed,
+			reasons:          []reason{},
+			checkOut:         true,
+			expectedDecision: decisionAllowed,
+			expectedReasons:  []reason{},
+			count:            1,
+		},
+		{
+			name:             "check out keeps the decision",
+			window:           3 * time.Hour,
+			inside:           []string{"c1"},
+			at:               time.Hour,
+			decision:         decisionDenied,
+			reasons:          []reason{reasonDebt},
+			checkOut:         true,
+			expectedDecision: decisionDenied,
+			expectedReasons:  []reason{reasonDebt},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &API{occupancy: newOccupancy(tc.capacity, tc.window, 0, true), policy: mustPolicy(nil, 0, 0)}
+			for _, c := range tc.inside {
+				a.occupancy.checkIn(c, start)
+			}
+			now := start.Add(tc.at)
+			e := scanEvent{Decision: tc.decision, Reasons: tc.reasons}
+			if record := a.admit(&e, "C1", now); record != tc.record {
+				t.Errorf("expected a visit to be recorded: %t, got %t", tc.record, record)
+			}
+			if e.CheckOut != tc.checkOut {
+				t.Errorf("expected a check-out: %t, got %t", tc.checkOut, e.CheckOut)
+			}
+			if e.Decision != tc.expectedDecision {
+				t.Errorf("expected decision %q, got %q", tc.expectedDecision, e.Decision)
+			}
+			if !equalReasons(e.Reasons, tc.expectedReasons) {
+				t.Errorf("expected reasons %v, got %v", tc.expectedReasons, e.Reasons)
+			}
+			if count := a.occupancy.count(now); count != tc.count {
+				t.Errorf("expected %d people inside, got %d", tc.count, count)
+			}
+		})
+	}
+}
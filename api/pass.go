@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pass is a day pass for a drop-in visitor or the guest of a member.
+type pass struct {
+	// ID identifies the pass; for Google Sheets, it is the row of the pass.
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Host is the BSID of the member who brought the guest; it is empty for drop-in visitors.
+	Host string `json:"host"`
+	// Price is the price of the pass in cents.
+	Price int64 `json:"price"`
+	// From is the first day on which the pass is valid.
+	From time.Time `json:"from"`
+	// Until is the last day on which the pass is valid.
+	Until time.Time `json:"until"`
+	// RFID is the temporary card that is bound to the pass, if any.
+	RFID string `json:"rfid"`
+}
+
+// active returns true if the pass is valid at the given time.
+func (p *pass) active(now time.Time) bool {
+	return !now.Before(p.From) && now.Before(p.Until.AddDate(0, 0, 1))
+}
+
+// findPass returns the pass to which the given temporary card is bound.
+// If the card was bound to several passes, the most recent one is returned.
+func findPass(ctx context.Context, s Store, rfid string) (*pass, error) {
+	passes, err := s.Passes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get passes: %v", err)
+	}
+	var found *pass
+	for i := range passes {
+		if passes[i].RFID != "" && passes[i].RFID == strings.ToLower(rfid) && (found == nil || passes[i].Until.After(found.Until)) {
+			found = &passes[i]
+		}
+	}
+	if found == nil {
+		return nil, &notFoundError{"pass", rfid}
+	}
+	return found, nil
+}
+
+// rowToPass converts a row of the pass sheet to a pass struct.
+func rowToPass(row []interface{}, id int) (*pass, error) {
+	cells := make([]string, 6)
+	for i := range row {
+		if i >= len(cells) {
+			break
+		}
+		v, ok := row[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse pass field %d", i+1)
+		}
+		cells[i] = strings.TrimSpace(v)
+	}
+	if cells[0] == "" {
+		return nil, errors.New("the pass has no name")
+	}
+	p := &pass{ID: id, Name: cells[0], Host: strings.ToLower(cells[1]), RFID: strings.ToLower(cells[5])}
+	var err error
+	if cells[2] != "" {
+		if p.Price, err = parseAmount(cells[2]); err != nil {
+			return nil, err
+		}
+	}
+	if p.From, err = time.ParseInLocation(dateFormat, cells[3], loc); err != nil {
+		return nil, fmt.Errorf("failed to parse pass start as date %v", err)
+	}
+	if p.Until, err = time.ParseInLocation(dateFormat, cells[4], loc); err != nil {
+		return nil, fmt.Errorf("failed to parse pass end as date %v", err)
+	}
+	return p, nil
+}
+
+func passToRow(p *pass) []interface{} {
+	return []interface{}{
+		p.Name,
+		strings.ToLower(p.Host),
+		formatAmount(p.Price),
+		p.From.Format(dateFormat),
+		p.Until.Format(dateFormat),
+		strings.ToLower(p.RFID),
+	}
+}
+
+// getPassesHandler allows the client to list the day passes.
+func (a *API) getPassesHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	passes, err := s.Passes(r.Context())
+	if err != nil {
+		log.Errorf("failed to list passes: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if passes == nil {
+		passes = []pass{}
+	}
+	writeJSON(passes).ServeHTTP(w, r)
+}
+
+// createPassHandler allows the client to issue a day pass.
+// The pass is valid for today unless a validity window is given.
+func (a *API) createPassHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	var req struct {
+		Name  string     `json:"name"`
+		Host  string     `json:"host"`
+		Price int64      `json:"price"`
+		From  *time.Time `json:"from"`
+		Until *time.Time `json:"until"`
+		RFID  string     `json:"rfid"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	defer r.Body.Close()
+	now := time.Now()
+	p := pass{
+		Name:  strings.TrimSpace(req.Name),
+		Host:  strings.ToLower(req.Host),
+		Price: req.Price,
+		From:  midnight(now),
+		RFID:  strings.ToLower(req.RFID),
+	}
+	if req.From != nil {
+		p.From = midnight(*req.From)
+	}
+	p.Until = p.From
+	if req.Until != nil {
+		p.Until = midnight(*req.Until)
+	}
+	switch {
+	case p.Name == "":
+		err = errors.New("the pass has no name")
+	case p.Price < 0:
+		err = errors.New("the price must not be negative")
+	case p.Until.Before(p.From):
+		err = errors.New("the pass must not end before it starts")
+	}
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	if p.Host != "" {
+		if _, err := s.UserByBSID(r.Context(), p.Host); err != nil {
+			if _, ok := err.(*notFoundError); ok {
+				writeJSONError(fmt.Errorf("the host does not exist: %v", err), http.StatusBadRequest).ServeHTTP(w, r)
+				return
+			}
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+	}
+	if p.RFID != "" {
+		if err := checkCardAvailable(r.Context(), s, p.RFID, ""); err != nil {
+			if _, ok := err.(*conflictError); ok {
+				writeJSONError(err, http.StatusConflict).ServeHTTP(w, r)
+				return
+			}
+			writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+			return
+		}
+		if existing, err := findPass(r.Context(), s, p.RFID); err == nil && !existing.Until.Before(p.From) {
+			writeJSONError(fmt.Errorf("card %q is still bound to pass %d", p.RFID, existing.ID), http.StatusConflict).ServeHTTP(w, r)
+			return
+		}
+	}
+	if err := s.CreatePass(r.Context(), &p); err != nil {
+		log.Errorf("failed to create pass: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(p).ServeHTTP(w, r)
+}
+
+// passScanEvent returns the scan event for the given pass at the given time.
+func passScanEvent(p *pass, pol *policy, duplicate bool, now time.Time) scanEvent {
+	e := scanEvent{Duplicate: duplicate, Pass: p, Status: statusValid}
+	var reasons []reason
+	if !p.active(now) {
+		e.Status = statusExpired
+		reasons = append(reasons, reasonExpired)
+	}
+	if duplicate {
+		reasons = append(reasons, reasonPassback)
+	}
+	e.Decision, e.Reasons = pol.decide(reasons)
+	e.ExpiresIn = days(now, p.Until.AddDate(0, 0, 1))
+	return e
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// scanTracker remembers when each card was last scanned
+// so that repeat scans within a window can be suppressed.
+type scanTracker struct {
+	sync.Mutex
+	// debounce is the time after a scan during which scans of the same card are the same tap rather than repeats,
+	// e.g. because the scan is handled once for every session.
+	debounce time.Duration
+	last     map[string]time.Time
+	window   time.Duration
+}
+
+func newScanTracker(window, debounce time.Duration) *scanTracker {
+	return &scanTracker{debounce: debounce, last: make(map[string]time.Time), window: window}
+}
+
+// seen returns true if the given key was already seen within the window before the given time,
+// unless it was seen within the debounce time, in which case it is the same tap.
+// Otherwise, the key is remembered as seen at the given time.
+// A tracker with no window never reports any duplicates.
+func (t *scanTracker) seen(key string, now time.Time) bool {
+	if t.window <= 0 {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+	for k, last := range t.last {
+		if now.Sub(last) >= t.window {
+			delete(t.last, k)
+		}
+	}
+	if last, ok := t.last[key]; ok {
+		return now.Sub(last) >= t.debounce
+	}
+	t.last[key] = now
+	return false
+}
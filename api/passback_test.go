@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScanTrackerSeen(t *testing.T) {
+	start := time.Date(2020, time.March, 2, 18, 0, 0, 0, loc)
+	// scan is a key that is scanned at a time since the start.
+	type scan struct {
+		key string
+		at  time.Duration
+	}
+	for _, tc := range []struct {
+		name     string
+		window   time.Duration
+		debounce time.Duration
+		scans    []scan
+		expected []bool
+	}{
+		{
+			name:     "no window",
+			window:   0,
+			scans:    []scan{{"a", 0}, {"a", time.Second}},
+			expected: []bool{false, false},
+		},
+		{
+			name:     "repeat within the window",
+			window:   time.Minute,
+			scans:    []scan{{"a", 0}, {"a", 30 * time.Second}, {"b", 30 * time.Second}},
+			expected: []bool{false, true, false},
+		},
+		{
+			name:     "repeat after the window",
+			window:   time.Minute,
+			scans:    []scan{{"a", 0}, {"a", time.Minute}, {"a", 90 * time.Second}},
+			expected: []bool{false, false, true},
+		},
+		{
+			name:     "same tap within the debounce time",
+			window:   time.Minute,
+			debounce: 5 * time.Second,
+			scans:    []scan{{"a", 0}, {"a", time.Millisecond}, {"a", 5 * time.Second}, {"a", 5*time.Second + time.Millisecond}},
+			expected: []bool{false, false, true, true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st := newScanTracker(tc.window, tc.debounce)
+			for i, s := range tc.scans {
+				if seen := st.seen(s.key, start.Add(s.at)); seen != tc.expected[i] {
+					t.Errorf("scan %d: expected %t, got %t", i, tc.expected[i], seen)
+				}
+			}
+		})
+	}
+}
+
+func TestScanRecordsOneVisitPerTap(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		duplicate time.Duration
+		// sessions are the sheets of the sessions for which the tap is handled.
+		sessions []string
+		// taps is the number of times the card is tapped, right after one another.
+		taps     int
+		expected int
+	}{
+		{
+			name:      "one session",
+			duplicate: time.Minute,
+			sessions:  []string{localSheetID},
+			taps:      1,
+			expected:  1,
+		},
+		{
+			name:      "several sessions",
+			duplicate: time.Minute,
+			sessions:  []string{localSheetID, localSheetID, localSheetID},
+			taps:      1,
+			expected:  1,
+		},
+		{
+			name:     "several sessions without a duplicate window",
+			sessions: []string{localSheetID, localSheetID, localSheetID},
+			taps:     1,
+			expected: 1,
+		},
+		{
+			name:      "several taps",
+			duplicate: time.Minute,
+			sessions:  []string{localSheetID, localSheetID},
+			taps:      3,
+			expected:  1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStore(t, user{BSID: "a", ID: "c1"})
+			a := newTestAPI(s)
+			a.scans = newScanTracker(tc.duplicate, tapDebounce)
+			for i := 0; i < tc.taps; i++ {
+				for j, sid := range tc.sessions {
+					e, err := a.scan(s, fmt.Sprintf("staff%d@example.com", j), sid, "c1")
+					if err != nil {
+						t.Fatalf("failed to scan: %v", err)
+					}
+					if e.Duplicate {
+						t.Errorf("tap %d in session %d: expected the tap not to be a duplicate", i, j)
+					}
+				}
+			}
+			visits, err := s.Visits(context.Background())
+			if err != nil {
+				t.Fatalf("failed to list visits: %v", err)
+			}
+			if len(visits) != tc.expected {
+				t.Errorf("expected %d visits, got %d", tc.expected, len(visits))
+			}
+		})
+	}
+}
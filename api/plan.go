@@ -0,0 +1,229 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// plan is a kind of membership that members can renew onto.
+type plan struct {
+	Name string `json:"name"`
+	// Duration is how long the plan lasts, e.g. "30d", "2w", "1m", or "1y".
+	Duration string `json:"duration"`
+	// Price is the price of the plan in cents.
+	Price int64 `json:"price"`
+}
+
+// validate returns an error if the plan cannot be used.
+func (p *plan) validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("the plan has no name")
+	}
+	if _, _, _, err := parsePeriod(p.Duration); err != nil {
+		return err
+	}
+	if p.Price < 0 {
+		return errors.New("the price must not be negative")
+	}
+	return nil
+}
+
+// renew returns the expiration of a membership with the given expiration that is renewed onto the plan at the given time.
+// Memberships that are still running are extended; expired ones start over from the given time.
+func (p *plan) renew(expiration, now time.Time) (time.Time, error) {
+	years, months, days, err := parsePeriod(p.Duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now = now.In(loc)
+	base := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if expiration.After(base) {
+		base = expiration
+	}
+	return addPeriod(base, years, months, days), nil
+}
+
+// addPeriod adds the given calendar period to the given time.
+// Unlike time.AddDate, adding months to the end of a month stays in the
+// target month, e.g. January 31st plus one month is February 28th.
+func addPeriod(t time.Time, years, months, days int) time.Time {
+	y, m, d := t.Date()
+	target := time.Date(y+years, m+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	if last := target.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	h, min, sec := t.Clock()
+	return time.Date(target.Year(), target.Month(), d, h, min, sec, t.Nanosecond(), t.Location()).AddDate(0, 0, days)
+}
+
+// parsePeriod parses a calendar period like "30d", "2w", "1m", or "1y".
+func parsePeriod(s string) (years, months, days int, err error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return 0, 0, 0, fmt.Errorf("%q is not a valid duration; expected e.g. 30d, 2w, 1m, or 1y", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, 0, 0, fmt.Errorf("%q is not a valid duration; expected e.g. 30d, 2w, 1m, or 1y", s)
+	}
+	switch s[len(s)-1] {
+	case 'd':
+		return 0, 0, n, nil
+	case 'w':
+		return 0, 0, 7 * n, nil
+	case 'm':
+		return 0, n, 0, nil
+	case 'y':
+		return n, 0, 0, nil
+	}
+	return 0, 0, 0, fmt.Errorf("%q is not a valid duration; expected e.g. 30d, 2w, 1m, or 1y", s)
+}
+
+// rowToPlan converts a row of the plan sheet to a plan struct.
+func rowToPlan(row []interface{}) (*plan, error) {
+	if len(row) < 3 {
+		return nil, fmt.Errorf("the given row does not have the right number of fields; expected %d, got %d", 3, len(row))
+	}
+	name, ok := row[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse row field %q", "name")
+	}
+	duration, ok := row[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse row field %q", "duration")
+	}
+	price, ok := row[2].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse row field %q", "price")
+	}
+	p := &plan{Name: strings.TrimSpace(name), Duration: strings.TrimSpace(duration)}
+	var err error
+	if p.Price, err = parseAmount(price); err != nil {
+		return nil, err
+	}
+	return p, p.validate()
+}
+
+func planToRow(p *plan) []interface{} {
+	return []interface{}{p.Name, p.Duration, formatAmount(p.Price)}
+}
+
+// findPlan returns the plan with the given name from the given plans.
+func findPlan(plans []plan, name string) (*plan, error) {
+	for i := range plans {
+		if strings.EqualFold(plans[i].Name, name) {
+			return &plans[i], nil
+		}
+	}
+	return nil, &notFoundError{"plan", name}
+}
+
+// getPlansHandler allows the client to list the plans in the catalog.
+func (a *API) getPlansHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	plans, err := s.Plans(r.Context())
+	if err != nil {
+		log.Errorf("failed to list plans: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if plans == nil {
+		plans = []plan{}
+	}
+	writeJSON(plans).ServeHTTP(w, r)
+}
+
+// createPlanHandler allows the client to add a plan to the catalog.
+func (a *API) createPlanHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	var p plan
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	defer r.Body.Close()
+	if err := p.validate(); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	plans, err := s.Plans(r.Context())
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	if _, err := findPlan(plans, p.Name); err == nil {
+		writeJSONError(fmt.Errorf("plan %q already exists", p.Name), http.StatusConflict).ServeHTTP(w, r)
+		return
+	}
+	if err := s.CreatePlan(r.Context(), &p); err != nil {
+		log.Errorf("failed to create plan: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(p).ServeHTTP(w, r)
+}
+
+// renewUserHandler allows the client to renew the membership of a user onto a plan.
+func (a *API) renewUserHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := mux.Vars(r)["id"]
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	defer r.Body.Close()
+	plans, err := s.Plans(r.Context())
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	p, err := findPlan(plans, req.Plan)
+	if err != nil {
+		writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+		return
+	}
+	u, err := findUser(r.Context(), s, bsID, false)
+	if err != nil {
+		if _, ok := err.(*notFoundError); ok {
+			writeJSONError(err, http.StatusNotFound).ServeHTTP(w, r)
+			return
+		}
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	u.Expiration, err = p.renew(u.Expiration, time.Now())
+	if err != nil {
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	u.Plan = p.Name
+	if err := s.UpdateUser(r.Context(), u.BSID, u); err != nil {
+		log.Errorf("failed to renew user: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	writeJSON(u).ServeHTTP(w, r)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// decision is the outcome of evaluating the access policy for a scan.
+type decision string
+
+const (
+	decisionAllowed decision = "allowed"
+	decisionDenied  decision = "denied"
+	decisionWarning decision = "warning"
+)
+
+// reason is a machine-readable condition that the access policy reacts to.
+type reason string
+
+const (
+	reasonDebt         reason = "debt"
+	reasonExpired      reason = "expired"
+	reasonExpiringSoon reason = "expiring_soon"
+	reasonFrozen       reason = "frozen"
+	reasonGracePeriod  reason = "grace_period"
+	reasonGymFull      reason = "gym_full"
+	reasonLostCard     reason = "lost_card"
+	reasonNoCredits    reason = "no_credits"
+	reasonPassback     reason = "passback"
+	reasonRetiredCard  reason = "retired_card"
+	reasonUnknownCard  reason = "unknown_card"
+)
+
+// effect is what a rule of the access policy does to a scan.
+type effect string
+
+const (
+	effectAllow effect = "allow"
+	effectDeny  effect = "deny"
+	effectWarn  effect = "warn"
+)
+
+// defaultRules are the effects of each reason,
+// unless they are overridden in the configuration.
+var defaultRules = map[reason]effect{
+	reasonDebt:         effectDeny,
+	reasonExpired:      effectDeny,
+	reasonExpiringSoon: effectWarn,
+	reasonFrozen:       effectWarn,
+	reasonGracePeriod:  effectWarn,
+	reasonGymFull:      effectDeny,
+	reasonLostCard:     effectDeny,
+	reasonNoCredits:    effectDeny,
+	reasonPassback:     effectAllow,
+	reasonRetiredCard:  effectDeny,
+	reasonUnknownCard:  effectDeny,
+}
+
+// policy decides whether members may enter.
+type policy struct {
+	// rules maps the reasons for which a scan may be refused to their effects.
+	rules map[reason]effect
+	// graceDays is the number of days after a membership expires during which it is in its grace period.
+	graceDays int
+	// soonDays is the number of days before a membership expires during which it is expiring soon.
+	soonDays int
+}
+
+// ValidateRules returns an error if the given rules, keyed by reason, cannot be used
+// to configure the access policy.
+func ValidateRules(rules map[string]string) error {
+	_, err := newPolicy(rules, 0, 0)
+	return err
+}
+
+// newPolicy returns the access policy with the given rules, keyed by reason,
+// and the given grace and expiring soon periods in days;
+// reasons that are not given use their default effects.
+func newPolicy(rules map[string]string, graceDays, soonDays int) (*policy, error) {
+	p := &policy{rules: make(map[reason]effect, len(defaultRules)), graceDays: graceDays, soonDays: soonDays}
+	for r, e := range defaultRules {
+		p.rules[r] = e
+	}
+	for r, e := range rules {
+		if _, ok := defaultRules[reason(r)]; !ok {
+			return nil, fmt.Errorf("%q is not a known reason", r)
+		}
+		switch effect(e) {
+		case effectAllow, effectDeny, effectWarn:
+		default:
+			return nil, fmt.Errorf("%q is not a valid effect for %q; expected one of allow, deny, or warn", e, r)
+		}
+		p.rules[reason(r)] = effect(e)
+	}
+	return p, nil
+}
+
+// mustPolicy returns the access policy with the given rules or, if they are invalid, the default rules.
+func mustPolicy(rules map[string]string, graceDays, soonDays int) *policy {
+	p, err := newPolicy(rules, graceDays, soonDays)
+	if err != nil {
+		log.Errorf("failed to configure the access policy; using the default rules: %v", err)
+		p, _ = newPolicy(nil, graceDays, soonDays)
+	}
+	return p
+}
+
+// expiresIn returns the number of days from the given time until the membership of the given user expires in Berlin;
+// it is zero on the day of expiration and negative afterwards.
+func expiresIn(u *user, now time.Time) int {
+	return days(now, u.EffectiveExpiration)
+}
+
+// conditions returns the reasons for which the given user may be refused at the given time.
+func (p *policy) conditions(u *user, now time.Time) []reason {
+	var reasons []reason
+	switch n := expiresIn(u, now); {
+	case !now.Before(u.EffectiveExpiration) && -n < p.graceDays:
+		reasons = append(reasons, reasonGracePeriod)
+	case !now.Before(u.EffectiveExpiration):
+		reasons = append(reasons, reasonExpired)
+	case n <= p.soonDays:
+		reasons = append(reasons, reasonExpiringSoon)
+	}
+	if u.Frozen {
+		reasons = append(reasons, reasonFrozen)
+	}
+	if u.Debt {
+		reasons = append(reasons, reasonDebt)
+	}
+	if u.Credits != nil && *u.Credits <= 0 {
+		reasons = append(reasons, reasonNoCredits)
+	}
+	return reasons
+}
+
+// decide returns the decision for the given reasons, along with the reasons that were not allowed by the policy.
+func (p *policy) decide(reasons []reason) (decision, []reason) {
+	d := decisionAllowed
+	matched := []reason{}
+	for _, r := range reasons {
+		d, matched = p.add(d, matched, r)
+	}
+	return d, matched
+}
+
+// add returns the given decision and matched reasons as changed by the given reason.
+func (p *policy) add(d decision, matched []reason, r reason) (decision, []reason) {
+	switch p.rules[r] {
+	case effectDeny:
+		d = decisionDenied
+	case effectWarn:
+		if d == decisionAllowed {
+			d = decisionWarning
+		}
+	default:
+		return d, matched
+	}
+	return d, append(matched, r)
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	minReplayBackoff = 5 * time.Second
+	maxReplayBackoff = 10 * time.Minute
+)
+
+// queuedVisit is a visit that could not be recorded, along with
+// the client and sheet that it should be recorded for.
+type queuedVisit struct {
+	Email string `json:"email"`
+	Sheet string `json:"sheet"`
+	Visit visit  `json:"visit"`
+	// Attempts is the number of times that recording the visit failed while replaying the queue.
+	Attempts int `json:"attempts,omitempty"`
+	// Next is the earliest time at which to try recording the visit again.
+	Next time.Time `json:"next"`
+}
+
+// backoff returns the time to wait before trying to record the visit again after the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	b := minReplayBackoff
+	for i := 1; i < attempts && b < maxReplayBackoff; i++ {
+		b *= 2
+	}
+	if b > maxReplayBackoff {
+		return maxReplayBackoff
+	}
+	return b
+}
+
+// permanent returns true if recording a visit failed in a way that retrying cannot fix,
+// e.g. because the sheet no longer exists or refuses the row.
+func permanent(err error) bool {
+	switch e := err.(type) {
+	case *googleapi.Error:
+		return e.Code == http.StatusBadRequest || e.Code == http.StatusNotFound
+	case *notFoundError:
+		return true
+	}
+	return false
+}
+
+// visitQueue holds the visits that failed to be recorded so they can
+// be retried later. If the queue has a path, then the visits are
+// journaled to disk so they survive restarts, and the visits that can never be recorded
+// are moved to a second journal with the suffix ".failed" so that they can be recovered by hand.
+type visitQueue struct {
+	sync.Mutex
+	depth  prometheus.Gauge
+	path   string
+	visits []queuedVisit
+}
+
+// newVisitQueue returns a new visit queue that journals to the given
+// path and loads any visits left in the journal from a previous run.
+// If the path is empty, the queue is only kept in memory.
+func newVisitQueue(path string, depth prometheus.Gauge) *visitQueue {
+	q := &visitQueue{depth: depth, path: path}
+	if path == "" {
+		return q
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("failed to open visit journal: %v", err)
+		}
+		return q
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var v queuedVisit
+		if err := json.Unmarshal(s.Bytes(), &v); err != nil {
+			log.Warnf("skipping malformed visit in journal: %v", err)
+			continue
+		}
+		q.visits = append(q.visits, v)
+	}
+	if err := s.Err(); err != nil {
+		log.Errorf("failed to read visit journal: %v", err)
+	}
+	q.depth.Set(float64(len(q.visits)))
+	return q
+}
+
+// add appends the given visit to the queue and the journal.
+func (q *visitQueue) add(v queuedVisit) {
+	q.Lock()
+	defer q.Unlock()
+	q.visits = append(q.visits, v)
+	q.depth.Set(float64(len(q.visits)))
+	if q.path == "" {
+		return
+	}
+	if err := appendJournal(q.path, []queuedVisit{v}); err != nil {
+		log.Errorf("failed to write visit to journal: %v", err)
+	}
+}
+
+// appendJournal appends the given visits to the journal at the given path, creating it if needed.
+func appendJournal(path string, visits []queuedVisit) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, v := range visits {
+		j, err := json.Marshal(v)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(append(j, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// replay tries to record the queued visits that are due at the given time using the given function.
+// A visit that fails is retried with its own exponential backoff, so it never holds up the visits behind it,
+// and a visit that fails permanently is dropped from the queue.
+// It returns the number of visits recorded and the last error of the visits that will be retried.
+func (q *visitQueue) replay(record func(queuedVisit) error, now time.Time) (int, error) {
+	q.Lock()
+	visits := make([]queuedVisit, len(q.visits))
+	copy(visits, q.visits)
+	q.Unlock()
+	var n int
+	var err error
+	var failed []queuedVisit
+	kept := make([]queuedVisit, 0, len(visits))
+	for _, v := range visits {
+		if v.Next.After(now) {
+			kept = append(kept, v)
+			continue
+		}
+		rerr := record(v)
+		switch {
+		case rerr == nil:
+			n++
+		case permanent(rerr):
+			log.Errorf("dropping queued visit of %q at %s, which cannot be recorded: %v", v.Visit.BSID, v.Visit.Time.Format(time.RFC3339), rerr)
+			failed = append(failed, v)
+		default:
+			err = rerr
+			v.Attempts++
+			v.Next = now.Add(backoff(v.Attempts))
+			kept = append(kept, v)
+		}
+	}
+	q.Lock()
+	defer q.Unlock()
+	// Visits are only ever appended, so the ones that were replayed are still at the front.
+	q.visits = append(kept, q.visits[len(visits):]...)
+	q.depth.Set(float64(len(q.visits)))
+	if werr := q.write(); werr != nil {
+		log.Errorf("failed to rewrite visit journal: %v", werr)
+	}
+	if len(failed) != 0 && q.path != "" {
+		if ferr := appendJournal(q.path+".failed", failed); ferr != nil {
+			log.Errorf("failed to write the visits that cannot be recorded to the journal: %v", ferr)
+		}
+	}
+	return n, err
+}
+
+// len returns the number of queued visits.
+func (q *visitQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.visits)
+}
+
+// write replaces the journal with the currently queued visits.
+// The caller must hold the lock.
+func (q *visitQueue) write() error {
+	if q.path == "" {
+		return nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(q.path), filepath.Base(q.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, v := range q.visits {
+		j, err := json.Marshal(v)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(j, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.path)
+}
+
+// replayVisits periodically retries recording the queued visits
+// until they succeed or fail permanently.
+func (a *API) replayVisits() {
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-time.After(minReplayBackoff):
+		}
+		if a.queue.len() == 0 {
+			continue
+		}
+		n, err := a.queue.replay(func(v queuedVisit) error {
+			if _, ok := a.client(v.Email); !ok && a.config.Store == nil {
+				return fmt.Errorf("no client for %q; waiting for them to log in", v.Email)
+			}
+			return a.store(v.Email, v.Sheet).RecordVisit(context.Background(), &v.Visit)
+		}, time.Now())
+		if n > 0 {
+			log.Infof("recorded %d queued visits", n)
+		}
+		if err != nil {
+			log.Warnf("failed to record queued visits; retrying them later: %v", err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/api/googleapi"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: minReplayBackoff},
+		{attempts: 2, expected: 2 * minReplayBackoff},
+		{attempts: 3, expected: 4 * minReplayBackoff},
+		{attempts: 100, expected: maxReplayBackoff},
+	} {
+		if b := backoff(tc.attempts); b != tc.expected {
+			t.Errorf("expected a backoff of %s after %d attempts, got %s", tc.expected, tc.attempts, b)
+		}
+	}
+}
+
+func TestVisitQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "berlinstrength")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "visits.journal")
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_visit_queue_depth"})
+	q := newVisitQueue(path, depth)
+	start := time.Now()
+	for _, bsID := range []string{"gone", "flaky", "ok"} {
+		q.add(queuedVisit{Email: testEmail, Sheet: localSheetID, Visit: visit{BSID: bsID, Time: start}})
+	}
+	// errs holds the error with which recording a visit of each member fails.
+	errs := map[string]error{
+		"gone":  &googleapi.Error{Code: http.StatusNotFound, Message: "Requested entity was not found."},
+		"flaky": errors.New("connection reset by peer"),
+	}
+	var attempted []string
+	record := func(v queuedVisit) error {
+		attempted = append(attempted, v.Visit.BSID)
+		return errs[v.Visit.BSID]
+	}
+	for _, tc := range []struct {
+		name      string
+		at        time.Duration
+		fixed     bool
+		attempted []string
+		recorded  int
+		queued    int
+	}{
+		{
+			name:      "permanent and retryable failures",
+			attempted: []string{"gone", "flaky", "ok"},
+			recorded:  1,
+			queued:    1,
+		},
+		{
+			name:   "before the backoff",
+			at:     minReplayBackoff / 2,
+			queued: 1,
+		},
+		{
+			name:      "after the backoff",
+			at:        minReplayBackoff,
+			attempted: []string{"flaky"},
+			queued:    1,
+		},
+		{
+			name:      "after the doubled backoff",
+			at:        3 * minReplayBackoff,
+			fixed:     true,
+			attempted: []string{"flaky"},
+			recorded:  1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attempted = nil
+			if tc.fixed {
+				delete(errs, "flaky")
+			}
+			n, _ := q.replay(record, start.Add(tc.at))
+			if strings.Join(attempted, ",") != strings.Join(tc.attempted, ",") {
+				t.Errorf("expected attempts to record %v, got %v", tc.attempted, attempted)
+			}
+			if n != tc.recorded {
+				t.Errorf("expected %d recorded visits, got %d", tc.recorded, n)
+			}
+			if l := q.len(); l != tc.queued {
+				t.Errorf("expected %d queued visits, got %d", tc.queued, l)
+			}
+			// The journal must hold exactly the queued visits.
+			if l := newVisitQueue(path, depth).len(); l != tc.queued {
+				t.Errorf("expected %d visits in the journal, got %d", tc.queued, l)
+			}
+		})
+	}
+	failed := newVisitQueue(path+".failed", depth)
+	if failed.len() != 1 || failed.visits[0].Visit.BSID != "gone" {
+		t.Errorf("expected the visit of %q to be moved to the failed journal, got %v", "gone", failed.visits)
+	}
+}
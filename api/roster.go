@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// roster is an in-memory copy of the members, cards, debts, and freezes of a sheet,
+// indexed so that scans can be resolved without reading the sheet.
+type roster struct {
+	// users holds the members in the order of their rows.
+	users   []*user
+	byBSID  map[string]*user
+	byEmail map[string]*user
+	byRFID  map[string]*user
+	cards   map[string][]card
+	debts   map[string][]debt
+	freezes map[string][]freeze
+}
+
+// rosterCache holds the rosters of the sheets that are in use.
+type rosterCache struct {
+	sync.Mutex
+	// generations counts the invalidations of the roster of each sheet,
+	// so that rosters that were fetched before an invalidation are not cached.
+	generations map[string]uint64
+	rosters     map[string]*roster
+}
+
+func newRosterCache() *rosterCache {
+	return &rosterCache{generations: make(map[string]uint64), rosters: make(map[string]*roster)}
+}
+
+// get returns the cached roster for the given sheet, if any, and the generation of the cache for the sheet.
+// The generation must be passed to set along with a roster that is fetched after calling get.
+func (rc *rosterCache) get(sid string) (*roster, uint64, bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	r, ok := rc.rosters[sid]
+	return r, rc.generations[sid], ok
+}
+
+// set caches the roster for the given sheet unless the cache was invalidated since the given generation,
+// in which case the roster may be stale.
+func (rc *rosterCache) set(sid string, gen uint64, r *roster) {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.generations[sid] != gen {
+		return
+	}
+	rc.rosters[sid] = r
+}
+
+// invalidate drops the cached roster for the given sheet.
+func (rc *rosterCache) invalidate(sid string) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.generations[sid]++
+	delete(rc.rosters, sid)
+}
+
+// fetchRoster reads the members, cards, debts, and freezes of the sheet and indexes them.
+// Rows that cannot be parsed are skipped.
+// If several rows share an ID, the first one wins, just like a linear scan of the sheet.
+func (s *sheetsStore) fetchRoster(ctx context.Context) (*roster, error) {
+	res, err := getRanges(ctx, s.c, s.sid, userRange, debtRange, freezeRange, cardRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(res.ValueRanges[0], s.headers)
+	if err != nil {
+		return nil, err
+	}
+	users := userRangeToUsers(res.ValueRanges[0], cols)
+	return newRoster(users, cardRangeToCards(res.ValueRanges[3]), debtRangeToDebts(res.ValueRanges[1]), freezeRangeToFreezes(res.ValueRanges[2])), nil
+}
+
+// newRoster indexes the given users; the cards, debts, and freezes are keyed by BSID.
+// If several users share an ID, the first one wins.
+func newRoster(users []*user, cards map[string][]card, debts map[string][]debt, freezes map[string][]freeze) *roster {
+	r := &roster{
+		users:   users,
+		byBSID:  make(map[string]*user),
+		byEmail: make(map[string]*user),
+		byRFID:  make(map[string]*user),
+		cards:   cards,
+		debts:   debts,
+		freezes: freezes,
+	}
+	for _, u := range r.users {
+		if _, ok := r.byBSID[u.BSID]; !ok {
+			r.byBSID[u.BSID] = u
+		}
+		if _, ok := r.byEmail[u.Email]; u.Email != "" && !ok {
+			r.byEmail[u.Email] = u
+		}
+		if _, ok := r.byRFID[u.ID]; u.ID != "" && !ok {
+			r.byRFID[u.ID] = u
+		}
+	}
+	return r
+}
+
+// members returns copies of the users of the roster with their cards, debts, and freezes populated
+// as of the given time, just like findUser.
+func (r *roster) members(now time.Time) []*user {
+	members := make([]*user, len(r.users))
+	for i := range r.users {
+		u := *r.users[i]
+		setDebts(&u, r.debts[u.BSID])
+		setFreezes(&u, r.freezes[u.BSID], now)
+		u.Cards = r.cards[u.BSID]
+		members[i] = &u
+	}
+	return members
+}
+
+// activeCards returns the RFIDs of the cards in the history that are active, keyed by the BSID of their holder.
+func (r *roster) activeCards() map[string][]string {
+	var cards []card
+	for _, cs := range r.cards {
+		cards = append(cards, cs...)
+	}
+	active := make(map[string][]string)
+	for _, c := range latestCards(cards) {
+		if c.Status == cardActive {
+			active[c.BSID] = append(active[c.BSID], c.RFID)
+		}
+	}
+	return active
+}
+
+// roster returns the cached roster of the sheet, fetching it if it is not cached.
+func (s *sheetsStore) roster(ctx context.Context) (*roster, error) {
+	r, gen, ok := s.cache.get(s.sid)
+	if ok {
+		return r, nil
+	}
+	r, err := s.fetchRoster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.set(s.sid, gen, r)
+	return r, nil
+}
+
+// refreshRoster drops the cached roster of the sheet and fetches it again in the background.
+func (s *sheetsStore) refreshRoster() {
+	s.cache.invalidate(s.sid)
+	go func() {
+		if _, err := s.roster(context.Background()); err != nil {
+			log.Warnf("failed to refresh roster: %v", err)
+		}
+	}()
+}
+
+// refreshRosters periodically fetches the rosters of all of the sheets in use
+// so that scans are resolved from memory.
+func (a *API) refreshRosters() {
+	if a.rosters == nil || a.config.Store != nil {
+		return
+	}
+	ticker := time.NewTicker(a.config.RosterRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+		refreshed := make(map[string]struct{})
+		for id, sid := range a.sessionSheets() {
+			if _, ok := refreshed[sid]; ok {
+				continue
+			}
+			c, ok := a.client(id)
+			if !ok {
+				continue
+			}
+			s := newSheetsStore(c, sid, a.config.Headers, a.rosters)
+			_, gen, _ := a.rosters.get(sid)
+			r, err := s.fetchRoster(context.Background())
+			if err != nil {
+				log.Warnf("failed to refresh roster: %v", err)
+				continue
+			}
+			a.rosters.set(sid, gen, r)
+			refreshed[sid] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import "testing"
+
+func TestRosterCacheSet(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// invalidations is the number of times the cache is invalidated while the roster is fetched.
+		invalidations int
+		cached        bool
+	}{
+		{
+			name:   "fetched after the latest invalidation",
+			cached: true,
+		},
+		{
+			name:          "fetched before an invalidation",
+			invalidations: 1,
+		},
+		{
+			name:          "fetched before several invalidations",
+			invalidations: 3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := newRosterCache()
+			rc.invalidate("sheet")
+			_, gen, _ := rc.get("sheet")
+			for i := 0; i < tc.invalidations; i++ {
+				rc.invalidate("sheet")
+			}
+			r := &roster{}
+			rc.set("sheet", gen, r)
+			cached, _, ok := rc.get("sheet")
+			if ok != tc.cached {
+				t.Fatalf("expected the roster to be cached: %t, got %t", tc.cached, ok)
+			}
+			if ok && cached != r {
+				t.Errorf("expected the fetched roster to be cached")
+			}
+		})
+	}
+}
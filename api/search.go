@@ -0,0 +1,259 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultPageSize is the number of results returned when the client does not ask for a limit.
+	defaultPageSize = 20
+	// maxPageSize is the largest number of results returned at once.
+	maxPageSize = 100
+)
+
+// foldings spell out the letters that staff may type in different ways,
+// so that e.g. "Müller", "Mueller", and "MUELLER" all match.
+var foldings = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'å': "a",
+	'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ñ': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u",
+	'ý': "y", 'ÿ': "y",
+}
+
+// fold normalizes the given string for searching: it is lowercased and
+// umlauts and accents are spelled out in plain ASCII.
+func fold(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if f, ok := foldings[r]; ok {
+			b.WriteString(f)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// words splits the given folded string into words at anything that is not a letter or a digit.
+func words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// distance returns the number of single-letter insertions, deletions, substitutions,
+// and transpositions of adjacent letters needed to turn one of the given strings into the other.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// d[i][j] is the distance between the first i letters of a and the first j letters of b.
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(min(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// typos returns the number of typos that are tolerated in a search term of the given length.
+func typos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matchTerm scores how well the given folded search term matches the given folded value;
+// zero means that it does not match at all. If fuzzy is true, the words of the value
+// may also match with a few typos.
+func matchTerm(term, value string, fuzzy bool) int {
+	switch {
+	case value == "":
+		return 0
+	case value == term:
+		return 100
+	case strings.HasPrefix(value, term):
+		return 80
+	}
+	best := 0
+	for _, w := range words(value) {
+		switch {
+		case w == term:
+			best = max(best, 90)
+		case strings.HasPrefix(w, term):
+			best = max(best, 70)
+		case fuzzy && typos(len(term)) > 0:
+			// Compare against the start of longer words so that a misspelled prefix still matches.
+			p := w
+			if r := []rune(w); len(r) > len([]rune(term))+1 {
+				p = string(r[:len([]rune(term))])
+			}
+			if d := distance(term, p); d <= typos(len(term)) {
+				best = max(best, 40-10*d)
+			}
+		}
+	}
+	if best == 0 && strings.Contains(value, term) {
+		best = 50
+	}
+	return best
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// score ranks the given user, who holds the given additional cards, for the given folded search terms;
+// zero means that the user does not match.
+// Every term must match the name, email, BSID, or the RFID of any active card of the user.
+func score(u *user, cards []string, terms []string) int {
+	name, email := fold(u.Name), fold(u.Email)
+	total := 0
+	for _, t := range terms {
+		best := max(matchTerm(t, name, true), matchTerm(t, email, true))
+		best = max(best, matchTerm(t, strings.ToLower(u.BSID), false))
+		best = max(best, matchTerm(t, strings.ToLower(u.ID), false))
+		for _, c := range cards {
+			best = max(best, matchTerm(t, c, false))
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+// searchUsers returns the users that match the given query, best matches first.
+// The active cards in the card history are keyed by the BSID of their holder.
+func searchUsers(users []*user, cards map[string][]string, q string) []*user {
+	terms := words(fold(q))
+	if len(terms) == 0 {
+		return nil
+	}
+	scores := make(map[*user]int)
+	var found []*user
+	for _, u := range users {
+		if s := score(u, cards[u.BSID], terms); s > 0 {
+			scores[u] = s
+			found = append(found, u)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if scores[found[i]] != scores[found[j]] {
+			return scores[found[i]] > scores[found[j]]
+		}
+		if ni, nj := fold(found[i].Name), fold(found[j].Name); ni != nj {
+			return ni < nj
+		}
+		return found[i].BSID < found[j].BSID
+	})
+	return found
+}
+
+// queryInt parses the query parameter with the given name as a non-negative integer;
+// if it is not set, the given default is returned.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a valid %s", v, name)
+	}
+	return n, nil
+}
+
+// pageSize parses the limit query parameter, which caps the number of results in a page.
+func pageSize(r *http.Request) (int, error) {
+	limit, err := queryInt(r, "limit", defaultPageSize)
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, nil
+}
+
+// searchUsersHandler allows the client to search for users by name, email, BSID, or the RFID of any of their active cards.
+// Letter case and umlauts are ignored and names and emails may contain a few typos.
+// The results are paged with the limit and offset query parameters.
+func (a *API) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" {
+		writeJSONError(errors.New("the search query is empty"), http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	limit, err := pageSize(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	ros, err := s.Roster(r.Context())
+	if err != nil {
+		log.Errorf("failed to list users: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	found := searchUsers(ros.members(time.Now()), ros.activeCards(), q)
+	page := []*user{}
+	if offset < len(found) {
+		page = found[offset:min(len(found), offset+limit)]
+	}
+	writeJSON(struct {
+		Total int     `json:"total"`
+		Users []*user `json:"users"`
+	}{len(found), page}).ServeHTTP(w, r)
+}
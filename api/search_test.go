@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t, user{BSID: "a", ID: "c1", Name: "Jörg Müller"}, user{BSID: "b", ID: "c2", Name: "Anna Schmidt"})
+	now := midnight(time.Now())
+	for _, c := range []card{
+		{BSID: "a", RFID: "r1", Status: cardRetired, Changed: now},
+		{BSID: "a", RFID: "x1", Status: cardActive, Changed: now},
+		{BSID: "a", RFID: "y1", Status: cardActive, Changed: now},
+		{BSID: "b", RFID: "y1", Status: cardActive, Changed: now},
+	} {
+		c := c
+		if err := s.AddCard(ctx, &c); err != nil {
+			t.Fatalf("failed to add card: %v", err)
+		}
+	}
+	ros, err := s.Roster(ctx)
+	if err != nil {
+		t.Fatalf("failed to read roster: %v", err)
+	}
+	for _, tc := range []struct {
+		name     string
+		q        string
+		expected []string
+	}{
+		{name: "name", q: "mueller", expected: []string{"a"}},
+		{name: "name with a typo", q: "schmit", expected: []string{"b"}},
+		{name: "card in the member sheet", q: "C1", expected: []string{"a"}},
+		{name: "active card in the history", q: "x1", expected: []string{"a"}},
+		{name: "retired card in the history", q: "r1"},
+		{name: "reassigned card in the history", q: "y1", expected: []string{"b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			found := searchUsers(ros.members(now), ros.activeCards(), tc.q)
+			var bsIDs []string
+			for _, u := range found {
+				bsIDs = append(bsIDs, u.BSID)
+			}
+			if len(bsIDs) != len(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, bsIDs)
+			}
+			for i := range bsIDs {
+				if bsIDs[i] != tc.expected[i] {
+					t.Errorf("expected %v, got %v", tc.expected, bsIDs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,1096 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// sheetsStore is a Store backed by a Google Sheet.
+// The columns of the member sheet are found by their headers in the first row.
+// If the store has a roster cache, then lookups are served from the cache.
+type sheetsStore struct {
+	c       client
+	cache   *rosterCache
+	headers map[string]string
+	sid     string
+}
+
+// NewSheetsStore returns a new Store for the Google Sheet with the given ID that is accessed with the given client,
+// e.g. for command line tools. The headers override the default headers of the member columns.
+func NewSheetsStore(oauthClient *http.Client, sid string, headers map[string]string) (Store, error) {
+	c, err := newClient(oauthClient)
+	if err != nil {
+		return nil, err
+	}
+	return newSheetsStore(*c, sid, headers, nil), nil
+}
+
+// newSheetsStore returns a new Store for the Google Sheet with the given ID.
+// The headers override the default headers of the member columns.
+// The cache may be nil, in which case every lookup reads the sheet.
+func newSheetsStore(c client, sid string, headers map[string]string, cache *rosterCache) *sheetsStore {
+	return &sheetsStore{c: c, cache: cache, headers: headers, sid: sid}
+}
+
+// UserByBSID implements the Store interface.
+func (s *sheetsStore) UserByBSID(ctx context.Context, bsID string) (*user, error) {
+	if s.cache != nil {
+		return s.cached(ctx, bsID, func(r *roster) map[string]*user { return r.byBSID })
+	}
+	u, _, _, _, err := s.find(ctx, bsID, bsIDField)
+	return u, err
+}
+
+// Users implements the Store interface.
+// The users are returned in the order of their rows; rows that cannot be parsed are skipped.
+func (s *sheetsStore) Users(ctx context.Context) ([]*user, error) {
+	if s.cache != nil {
+		r, err := s.roster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		users := make([]*user, len(r.users))
+		for i := range r.users {
+			// Return copies so that callers cannot modify the cache.
+			u := *r.users[i]
+			users[i] = &u
+		}
+		return users, nil
+	}
+	vr, err := s.c.sheets.Spreadsheets.Values.Get(s.sid, userRange).MajorDimension("ROWS").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(vr, s.headers)
+	if err != nil {
+		return nil, err
+	}
+	return userRangeToUsers(vr, cols), nil
+}
+
+// UserByRFID implements the Store interface.
+func (s *sheetsStore) UserByRFID(ctx context.Context, rfid string) (*user, error) {
+	if s.cache != nil {
+		return s.cached(ctx, rfid, func(r *roster) map[string]*user { return r.byRFID })
+	}
+	u, _, _, _, err := s.find(ctx, rfid, rfidField)
+	return u, err
+}
+
+// UserByEmail implements the Store interface.
+func (s *sheetsStore) UserByEmail(ctx context.Context, email string) (*user, error) {
+	if s.cache != nil {
+		return s.cached(ctx, email, func(r *roster) map[string]*user { return r.byEmail })
+	}
+	u, _, _, _, err := s.find(ctx, email, emailField)
+	return u, err
+}
+
+// cached looks up a user in the given index of the cached roster.
+func (s *sheetsStore) cached(ctx context.Context, id string, index func(*roster) map[string]*user) (*user, error) {
+	r, err := s.roster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := index(r)[strings.ToLower(id)]
+	if !ok {
+		return nil, &notFoundError{"user", id}
+	}
+	// Return a copy so that callers cannot modify the cache.
+	c := *u
+	return &c, nil
+}
+
+// CreateUser implements the Store interface.
+func (s *sheetsStore) CreateUser(ctx context.Context, u *user) error {
+	cols, err := s.columns(ctx)
+	if err != nil {
+		return err
+	}
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{userToRow(u, cols)},
+	}
+	_, err = s.c.sheets.Spreadsheets.Values.Append(s.sid, userRange, vr).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// CreateUsers implements the Store interface.
+// The users are appended to the member sheet in a single request.
+func (s *sheetsStore) CreateUsers(ctx context.Context, users []*user) error {
+	if len(users) == 0 {
+		return nil
+	}
+	cols, err := s.columns(ctx)
+	if err != nil {
+		return err
+	}
+	rows := make([][]interface{}, len(users))
+	for i, u := range users {
+		rows[i] = userToRow(u, cols)
+	}
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         rows,
+	}
+	if _, err := s.c.sheets.Spreadsheets.Values.Append(s.sid, userRange, vr).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx).Do(); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// UpdateUser implements the Store interface.
+// The user's row is always read from the sheet rather than the cache
+// so that a stale row number can never overwrite another member.
+func (s *sheetsStore) UpdateUser(ctx context.Context, bsID string, u *user) error {
+	_, n, existingRow, cols, err := s.find(ctx, bsID, bsIDField)
+	if err != nil {
+		return err
+	}
+	row := userToRow(u, cols)
+	for i := range existingRow {
+		if i >= len(row) {
+			break
+		}
+		if v, ok := row[i].(string); ok && v != "" {
+			continue
+		}
+		row[i] = existingRow[i]
+	}
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{row},
+	}
+	_, err = s.c.sheets.Spreadsheets.Values.Update(s.sid, fmt.Sprintf(userUpdateRange, n, n), vr).ValueInputOption("RAW").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// ArchiveUser implements the Store interface.
+// The row of the user is copied to the archive tab before it is removed from the member sheet,
+// so that a failure in between can never lose a member.
+func (s *sheetsStore) ArchiveUser(ctx context.Context, bsID string) error {
+	res, err := getRanges(ctx, s.c, s.sid, userRange, archiveRange)
+	if err != nil {
+		return fmt.Errorf("failed to batch get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(res.ValueRanges[0], s.headers)
+	if err != nil {
+		return err
+	}
+	_, n, row, err := userRangeToUser(res.ValueRanges[0], cols, bsID, bsIDField)
+	if err != nil {
+		return err
+	}
+	rows := [][]interface{}{row}
+	// The archive starts with the header of the member sheet so that its rows can be parsed the same way.
+	if len(res.ValueRanges[1].Values) == 0 {
+		rows = [][]interface{}{res.ValueRanges[0].Values[0], row}
+	}
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         rows,
+	}
+	if _, err := s.append(ctx, archiveRange, vr); err != nil {
+		return fmt.Errorf("failed to archive user: %v", err)
+	}
+	tabs, err := s.tabs(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := tabs[""]; !ok {
+		return errors.New("the spreadsheet has no tabs")
+	}
+	req := &sheets.BatchUpdateSpreadsheetRequest{Requests: deleteRows(tabs[""], []int{n})}
+	if _, err := s.c.sheets.Spreadsheets.BatchUpdate(s.sid, req).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to remove archived user from the member sheet: %v", err)
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// ArchivedUsers implements the Store interface.
+// The users are returned in the order of their rows; rows that cannot be parsed are skipped.
+func (s *sheetsStore) ArchivedUsers(ctx context.Context) ([]*user, error) {
+	vr, err := s.get(ctx, archiveRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the archive: %v", err)
+	}
+	// The archive is empty until the first user is archived, when it gets the header of the member sheet.
+	if len(vr.Values) == 0 {
+		return nil, nil
+	}
+	cols, err := userRangeToColumns(vr, s.headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the archive: %v", err)
+	}
+	return userRangeToUsers(vr, cols), nil
+}
+
+// DeleteUser implements the Store interface.
+// The rows of the user and of their visits are deleted and the rows of their cards, debts, and freezes are cleared
+// in a single batch update; the latter are only cleared because their row numbers are the IDs of the entries below them.
+func (s *sheetsStore) DeleteUser(ctx context.Context, bsID string) (*user, error) {
+	res, err := getRanges(ctx, s.c, s.sid, userRange, archiveRange, visitRange, cardRange, debtRange, freezeRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch get spreadsheet data: %v", err)
+	}
+	tab := ""
+	u, n, err := s.findRow(res.ValueRanges[0], bsID)
+	if _, ok := err.(*notFoundError); ok && len(res.ValueRanges[1].Values) != 0 {
+		tab = archiveTab
+		u, n, err = s.findRow(res.ValueRanges[1], bsID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The BSID is in the first column of each of the tabs that refer to the user.
+	rows := map[string][]int{tab: {n}}
+	for i, t := range []string{visitTab, cardTab, debtTab, freezeTab} {
+		for j, row := range res.ValueRanges[i+2].Values {
+			if len(row) == 0 {
+				continue
+			}
+			if v, ok := row[0].(string); ok && strings.EqualFold(strings.TrimSpace(v), u.BSID) {
+				// The Sheets API is not 0-index.
+				rows[t] = append(rows[t], j+1)
+			}
+		}
+	}
+	tabs, err := s.tabs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []*sheets.Request
+	for t, r := range rows {
+		// A missing tab would otherwise be mistaken for the tab with ID 0.
+		if _, ok := tabs[t]; !ok {
+			return nil, fmt.Errorf("the spreadsheet has no %q tab", t)
+		}
+		switch t {
+		case cardTab, debtTab, freezeTab:
+			reqs = append(reqs, clearRows(tabs[t], r)...)
+		default:
+			reqs = append(reqs, deleteRows(tabs[t], r)...)
+		}
+	}
+	if _, err := s.c.sheets.Spreadsheets.BatchUpdate(s.sid, &sheets.BatchUpdateSpreadsheetRequest{Requests: reqs}).Context(ctx).Do(); err != nil {
+		return nil, fmt.Errorf("failed to delete user: %v", err)
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return u, nil
+}
+
+// findRow finds the user with the given BSID in the given *sheets.ValueRange, whose first row is the header.
+func (s *sheetsStore) findRow(vr *sheets.ValueRange, bsID string) (*user, int, error) {
+	cols, err := userRangeToColumns(vr, s.headers)
+	if err != nil {
+		return nil, 0, err
+	}
+	u, n, _, err := userRangeToUser(vr, cols, bsID, bsIDField)
+	return u, n, err
+}
+
+// tabs returns the IDs of the tabs of the spreadsheet keyed by title.
+// The first tab, which holds the members, is also keyed by the empty string.
+func (s *sheetsStore) tabs(ctx context.Context) (map[string]int64, error) {
+	return getTabs(ctx, s.c, s.sid)
+}
+
+// getTabs returns the IDs of the tabs of the spreadsheet with the given ID keyed by title.
+// The first tab, which holds the members, is also keyed by the empty string.
+func getTabs(ctx context.Context, c client, sid string) (map[string]int64, error) {
+	res, err := c.sheets.Spreadsheets.Get(sid).Fields("sheets.properties(sheetId,title,index)").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet tabs: %v", err)
+	}
+	tabs := make(map[string]int64)
+	for _, sh := range res.Sheets {
+		if sh.Properties == nil {
+			continue
+		}
+		tabs[sh.Properties.Title] = sh.Properties.SheetId
+		if sh.Properties.Index == 0 {
+			tabs[""] = sh.Properties.SheetId
+		}
+	}
+	return tabs, nil
+}
+
+// get reads the given range of the spreadsheet; a range in a missing tab is read as empty.
+func (s *sheetsStore) get(ctx context.Context, rng string) (*sheets.ValueRange, error) {
+	res, err := getRanges(ctx, s.c, s.sid, rng)
+	if err != nil {
+		return nil, err
+	}
+	return res.ValueRanges[0], nil
+}
+
+// getRanges reads the given ranges of the spreadsheet with the given ID in a single request.
+// Spreadsheets that predate the cards, debts, freezes, and so on do not have their tabs,
+// so ranges in missing tabs are read as empty rather than failing every read;
+// the tab is added when its first row is appended.
+func getRanges(ctx context.Context, c client, sid string, ranges ...string) (*sheets.BatchGetValuesResponse, error) {
+	res, err := c.sheets.Spreadsheets.Values.BatchGet(sid).Ranges(ranges...).MajorDimension("ROWS").Context(ctx).Do()
+	// The Sheets API fails to parse a range in a missing tab.
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusBadRequest {
+		return res, err
+	}
+	tabs, terr := getTabs(ctx, c, sid)
+	if terr != nil {
+		return nil, err
+	}
+	var present []string
+	for _, r := range ranges {
+		if _, ok := tabs[rangeToTab(r)]; ok {
+			present = append(present, r)
+		}
+	}
+	if len(present) == len(ranges) {
+		return nil, err
+	}
+	found := new(sheets.BatchGetValuesResponse)
+	if len(present) != 0 {
+		if found, err = c.sheets.Spreadsheets.Values.BatchGet(sid).Ranges(present...).MajorDimension("ROWS").Context(ctx).Do(); err != nil {
+			return nil, err
+		}
+	}
+	res = &sheets.BatchGetValuesResponse{SpreadsheetId: sid, ValueRanges: make([]*sheets.ValueRange, len(ranges))}
+	for i, r := range ranges {
+		if _, ok := tabs[rangeToTab(r)]; ok && len(found.ValueRanges) != 0 {
+			res.ValueRanges[i], found.ValueRanges = found.ValueRanges[0], found.ValueRanges[1:]
+			continue
+		}
+		res.ValueRanges[i] = &sheets.ValueRange{Range: r, MajorDimension: "ROWS"}
+	}
+	return res, nil
+}
+
+// append appends the given rows to the tab of the given range, adding the tab if the spreadsheet does not have it yet.
+func (s *sheetsStore) append(ctx context.Context, rng string, vr *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	call := func() (*sheets.AppendValuesResponse, error) {
+		return s.c.sheets.Spreadsheets.Values.Append(s.sid, rng, vr).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx).Do()
+	}
+	res, err := call()
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusBadRequest {
+		return res, err
+	}
+	tab := rangeToTab(rng)
+	tabs, terr := s.tabs(ctx)
+	if terr != nil {
+		return nil, err
+	}
+	if _, ok := tabs[tab]; ok {
+		return nil, err
+	}
+	req := &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: tab}}}}}
+	if _, err := s.c.sheets.Spreadsheets.BatchUpdate(s.sid, req).Context(ctx).Do(); err != nil {
+		return nil, fmt.Errorf("failed to add the %q tab: %v", tab, err)
+	}
+	return call()
+}
+
+// rangeToTab returns the tab of the given A1 range; a range without a tab is in the first tab,
+// which is keyed by the empty string.
+func rangeToTab(a1 string) string {
+	if i := strings.LastIndex(a1, "!"); i != -1 {
+		return a1[:i]
+	}
+	return ""
+}
+
+// deleteRows returns the requests that delete the given 1-indexed rows of the tab with the given ID.
+// The rows are deleted from the bottom up so that deleting one row does not move the others.
+func deleteRows(tab int64, rows []int) []*sheets.Request {
+	sorted := make([]int, len(rows))
+	copy(sorted, rows)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	reqs := make([]*sheets.Request, 0, len(sorted))
+	for _, n := range sorted {
+		reqs = append(reqs, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    tab,
+					Dimension:  "ROWS",
+					StartIndex: int64(n - 1),
+					EndIndex:   int64(n),
+					// The first tab and the first row have the ID and index 0, which would otherwise be omitted.
+					ForceSendFields: []string{"SheetId", "StartIndex"},
+				},
+			},
+		})
+	}
+	return reqs
+}
+
+// clearRows returns the requests that clear the values of the given 1-indexed rows of the tab with the given ID,
+// without moving the rows below them.
+func clearRows(tab int64, rows []int) []*sheets.Request {
+	reqs := make([]*sheets.Request, 0, len(rows))
+	for _, n := range rows {
+		reqs = append(reqs, &sheets.Request{
+			UpdateCells: &sheets.UpdateCellsRequest{
+				Range: &sheets.GridRange{
+					SheetId:       tab,
+					StartRowIndex: int64(n - 1),
+					EndRowIndex:   int64(n),
+					// The first tab and the first row have the ID and index 0, which would otherwise be omitted.
+					ForceSendFields: []string{"SheetId", "StartRowIndex"},
+				},
+				// Updating the values without giving any rows clears them.
+				Fields: "userEnteredValue",
+			},
+		})
+	}
+	return reqs
+}
+
+// ReassignCard implements the Store interface.
+// The card cells of all of the affected members are written in a single batch update.
+func (s *sheetsStore) ReassignCard(ctx context.Context, rfid, bsID string) error {
+	vr, err := s.c.sheets.Spreadsheets.Values.Get(s.sid, userRange).MajorDimension("ROWS").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(vr, s.headers)
+	if err != nil {
+		return err
+	}
+	c, ok := cols.index[rfidField]
+	if !ok {
+		return errors.New("the member sheet has no RFID column")
+	}
+	var data []*sheets.ValueRange
+	var found bool
+	// Skip the header row.
+	for i := 1; i < len(vr.Values); i++ {
+		id, _ := cols.get(vr.Values[i], bsIDField)
+		current, _ := cols.get(vr.Values[i], rfidField)
+		var v string
+		switch {
+		case !found && strings.EqualFold(id, bsID):
+			found = true
+			v = strings.ToLower(rfid)
+		case strings.EqualFold(current, rfid):
+		default:
+			continue
+		}
+		// The Sheets API is not 0-index.
+		data = append(data, &sheets.ValueRange{
+			MajorDimension: "ROWS",
+			Range:          fmt.Sprintf("%s%d", columnName(c), i+1),
+			Values:         [][]interface{}{{v}},
+		})
+	}
+	if !found {
+		return &notFoundError{"user", bsID}
+	}
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "RAW", Data: data}
+	if _, err := s.c.sheets.Spreadsheets.Values.BatchUpdate(s.sid, req).Context(ctx).Do(); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// Debts implements the Store interface.
+func (s *sheetsStore) Debts(ctx context.Context, bsID string) ([]debt, error) {
+	if s.cache != nil {
+		r, err := s.roster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return r.debts[strings.ToLower(bsID)], nil
+	}
+	vr, err := s.get(ctx, debtRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet debt data: %v", err)
+	}
+	return debtRangeToDebts(vr)[strings.ToLower(bsID)], nil
+}
+
+// AddDebt implements the Store interface.
+func (s *sheetsStore) AddDebt(ctx context.Context, d *debt) error {
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{debtToRow(d)},
+	}
+	res, err := s.append(ctx, debtRange, vr)
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	if res.Updates == nil {
+		return errors.New("failed to find the row of the new debt")
+	}
+	d.ID, err = rangeToRow(res.Updates.UpdatedRange)
+	return err
+}
+
+// SettleDebt implements the Store interface.
+func (s *sheetsStore) SettleDebt(ctx context.Context, bsID string, id int) error {
+	vr, err := s.c.sheets.Spreadsheets.Values.Get(s.sid, fmt.Sprintf(debtRowRange, id, id)).MajorDimension("ROWS").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get spreadsheet debt data: %v", err)
+	}
+	if len(vr.Values) == 0 {
+		return &notFoundError{"debt", strconv.Itoa(id)}
+	}
+	d, err := rowToDebt(vr.Values[0], id)
+	if err != nil || d.BSID != strings.ToLower(bsID) {
+		return &notFoundError{"debt", strconv.Itoa(id)}
+	}
+	d.Settled = true
+	vr = &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{debtToRow(d)},
+	}
+	_, err = s.c.sheets.Spreadsheets.Values.Update(s.sid, fmt.Sprintf(debtRowRange, id, id), vr).ValueInputOption("RAW").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// Freezes implements the Store interface.
+func (s *sheetsStore) Freezes(ctx context.Context, bsID string) ([]freeze, error) {
+	if s.cache != nil {
+		r, err := s.roster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return r.freezes[strings.ToLower(bsID)], nil
+	}
+	vr, err := s.get(ctx, freezeRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet freeze data: %v", err)
+	}
+	return freezeRangeToFreezes(vr)[strings.ToLower(bsID)], nil
+}
+
+// AddFreeze implements the Store interface.
+func (s *sheetsStore) AddFreeze(ctx context.Context, f *freeze) error {
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{freezeToRow(f)},
+	}
+	res, err := s.append(ctx, freezeRange, vr)
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	if res.Updates == nil {
+		return errors.New("failed to find the row of the new freeze")
+	}
+	f.ID, err = rangeToRow(res.Updates.UpdatedRange)
+	return err
+}
+
+// EndFreeze implements the Store interface.
+func (s *sheetsStore) EndFreeze(ctx context.Context, bsID string, id int, end time.Time) error {
+	vr, err := s.c.sheets.Spreadsheets.Values.Get(s.sid, fmt.Sprintf(freezeRowRange, id, id)).MajorDimension("ROWS").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get spreadsheet freeze data: %v", err)
+	}
+	if len(vr.Values) == 0 {
+		return &notFoundError{"freeze", strconv.Itoa(id)}
+	}
+	f, err := rowToFreeze(vr.Values[0], id)
+	if err != nil || f.BSID != strings.ToLower(bsID) {
+		return &notFoundError{"freeze", strconv.Itoa(id)}
+	}
+	f.endAt(end)
+	vr = &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{freezeToRow(f)},
+	}
+	_, err = s.c.sheets.Spreadsheets.Values.Update(s.sid, fmt.Sprintf(freezeRowRange, id, id), vr).ValueInputOption("RAW").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	return nil
+}
+
+// Cards implements the Store interface.
+func (s *sheetsStore) Cards(ctx context.Context, bsID string) ([]card, error) {
+	if s.cache != nil {
+		r, err := s.roster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return r.cards[strings.ToLower(bsID)], nil
+	}
+	vr, err := s.get(ctx, cardRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet card data: %v", err)
+	}
+	return cardRangeToCards(vr)[strings.ToLower(bsID)], nil
+}
+
+// CardByRFID implements the Store interface.
+func (s *sheetsStore) CardByRFID(ctx context.Context, rfid string) (*card, error) {
+	var cards map[string][]card
+	if s.cache != nil {
+		r, err := s.roster(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cards = r.cards
+	} else {
+		vr, err := s.get(ctx, cardRange)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get spreadsheet card data: %v", err)
+		}
+		cards = cardRangeToCards(vr)
+	}
+	var found *card
+	for bsID := range cards {
+		for i := range cards[bsID] {
+			if c := &cards[bsID][i]; c.RFID == strings.ToLower(rfid) && (found == nil || c.ID > found.ID) {
+				found = c
+			}
+		}
+	}
+	if found == nil {
+		return nil, &notFoundError{"card", rfid}
+	}
+	c := *found
+	return &c, nil
+}
+
+// AddCard implements the Store interface.
+func (s *sheetsStore) AddCard(ctx context.Context, c *card) error {
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{cardToRow(c)},
+	}
+	res, err := s.append(ctx, cardRange, vr)
+	if err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.refreshRoster()
+	}
+	if res.Updates == nil {
+		return errors.New("failed to find the row of the new card")
+	}
+	c.ID, err = rangeToRow(res.Updates.UpdatedRange)
+	return err
+}
+
+// Plans implements the Store interface.
+func (s *sheetsStore) Plans(ctx context.Context) ([]plan, error) {
+	vr, err := s.get(ctx, planRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet plan data: %v", err)
+	}
+	var plans []plan
+	for i, row := range vr.Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		p, err := rowToPlan(row)
+		if err != nil {
+			// The Sheets API is not 0-index.
+			log.Debugf("skipping plan %d: %v", i+1, err)
+			continue
+		}
+		plans = append(plans, *p)
+	}
+	return plans, nil
+}
+
+// CreatePlan implements the Store interface.
+func (s *sheetsStore) CreatePlan(ctx context.Context, p *plan) error {
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{planToRow(p)},
+	}
+	_, err := s.append(ctx, planRange, vr)
+	return err
+}
+
+// Passes implements the Store interface.
+func (s *sheetsStore) Passes(ctx context.Context) ([]pass, error) {
+	vr, err := s.get(ctx, passRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet pass data: %v", err)
+	}
+	return passRangeToPasses(vr), nil
+}
+
+// CreatePass implements the Store interface.
+func (s *sheetsStore) CreatePass(ctx context.Context, p *pass) error {
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{passToRow(p)},
+	}
+	res, err := s.append(ctx, passRange, vr)
+	if err != nil {
+		return err
+	}
+	if res.Updates == nil {
+		return errors.New("failed to find the row of the new pass")
+	}
+	p.ID, err = rangeToRow(res.Updates.UpdatedRange)
+	return err
+}
+
+// Roster implements the Store interface.
+func (s *sheetsStore) Roster(ctx context.Context) (*roster, error) {
+	if s.cache != nil {
+		return s.roster(ctx)
+	}
+	return s.fetchRoster(ctx)
+}
+
+// Visits implements the Store interface.
+func (s *sheetsStore) Visits(ctx context.Context) ([]visit, error) {
+	vr, err := s.get(ctx, visitRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet visit data: %v", err)
+	}
+	var visits []visit
+	for i, row := range vr.Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		v, err := rowToVisit(row)
+		if err != nil {
+			// The Sheets API is not 0-index.
+			log.Debugf("skipping visit %d: %v", i+1, err)
+			continue
+		}
+		visits = append(visits, *v)
+	}
+	return visits, nil
+}
+
+// RecordVisit implements the Store interface.
+// Visits by holders of day passes have no BSID and refer to the row of the pass instead.
+func (s *sheetsStore) RecordVisit(ctx context.Context, v *visit) error {
+	row := []interface{}{v.BSID, v.Time.Format(time.RFC3339)}
+	if v.Pass != 0 {
+		row = append(row, strconv.Itoa(v.Pass))
+	}
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{row},
+	}
+	_, err := s.append(ctx, visitRange, vr)
+	return err
+}
+
+// snapshot reads all of the members, archived members, cards, debts, freezes, passes, plans, and visits in the sheet.
+// Rows that cannot be parsed are skipped.
+func (s *sheetsStore) snapshot(ctx context.Context) (*snapshot, error) {
+	res, err := getRanges(ctx, s.c, s.sid, userRange, debtRange, visitRange, planRange, freezeRange, passRange, cardRange, archiveRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(res.ValueRanges[0], s.headers)
+	if err != nil {
+		return nil, err
+	}
+	snap := new(snapshot)
+	for i, row := range res.ValueRanges[0].Values[1:] {
+		u, err := rowToUser(row, cols)
+		if err != nil {
+			// The Sheets API is not 0-index and the first row is the header.
+			log.Warnf("skipping row %d: failed to parse user: %v", i+2, err)
+			continue
+		}
+		snap.Users = append(snap.Users, u)
+	}
+	for i, row := range res.ValueRanges[1].Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		d, err := rowToDebt(row, i+1)
+		if err != nil {
+			log.Warnf("skipping debt %d: %v", i+1, err)
+			continue
+		}
+		snap.Debts = append(snap.Debts, *d)
+	}
+	for i, row := range res.ValueRanges[2].Values {
+		v, err := rowToVisit(row)
+		if err != nil {
+			log.Warnf("skipping visit %d: %v", i+1, err)
+			continue
+		}
+		snap.Visits = append(snap.Visits, *v)
+	}
+	for i, row := range res.ValueRanges[3].Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		p, err := rowToPlan(row)
+		if err != nil {
+			log.Warnf("skipping plan %d: %v", i+1, err)
+			continue
+		}
+		snap.Plans = append(snap.Plans, *p)
+	}
+	for i, row := range res.ValueRanges[4].Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		f, err := rowToFreeze(row, i+1)
+		if err != nil {
+			log.Warnf("skipping freeze %d: %v", i+1, err)
+			continue
+		}
+		snap.Freezes = append(snap.Freezes, *f)
+	}
+	snap.Passes = passRangeToPasses(res.ValueRanges[5])
+	for _, cards := range cardRangeToCards(res.ValueRanges[6]) {
+		snap.Cards = append(snap.Cards, cards...)
+	}
+	if archive := res.ValueRanges[7]; len(archive.Values) != 0 {
+		cols, err := userRangeToColumns(archive, s.headers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the archive: %v", err)
+		}
+		snap.Archived = userRangeToUsers(archive, cols)
+	}
+	// Keep the history in order so that the most recent entries stay the most recent ones.
+	sort.Slice(snap.Cards, func(i, j int) bool { return snap.Cards[i].ID < snap.Cards[j].ID })
+	return snap, nil
+}
+
+// columns reads the header row of the member sheet and maps the member fields to columns.
+func (s *sheetsStore) columns(ctx context.Context) (*columns, error) {
+	vr, err := s.c.sheets.Spreadsheets.Values.Get(s.sid, headerRange).MajorDimension("ROWS").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get spreadsheet header: %v", err)
+	}
+	return userRangeToColumns(vr, s.headers)
+}
+
+// find will look for a user in the sheet by either BSID or RFID, and return a pointer to the user, the row of the user in the spreadsheet, the raw row, the columns of the sheet, and any error.
+func (s *sheetsStore) find(ctx context.Context, scanID string, f field) (*user, int, []interface{}, *columns, error) {
+	vr, err := s.c.sheets.Spreadsheets.Values.Get(s.sid, userRange).MajorDimension("ROWS").Context(ctx).Do()
+	if err != nil {
+		return nil, 0, nil, nil, fmt.Errorf("failed to get spreadsheet data: %v", err)
+	}
+	cols, err := userRangeToColumns(vr, s.headers)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	u, i, row, err := userRangeToUser(vr, cols, scanID, f)
+	return u, i, row, cols, err
+}
+
+// userRangeToColumns maps the member fields to columns using the header row of the given *sheets.ValueRange.
+func userRangeToColumns(vr *sheets.ValueRange, headers map[string]string) (*columns, error) {
+	if len(vr.Values) == 0 {
+		return nil, errors.New("the member sheet has no header row")
+	}
+	return newColumns(vr.Values[0], headers)
+}
+
+// userRangeToUser converts a *sheets.ValueRange representing a user to a user struct.
+func userRangeToUser(vr *sheets.ValueRange, cols *columns, scanID string, f field) (*user, int, []interface{}, error) {
+	var i int
+	var row []interface{}
+	// Skip the header row.
+	for i = 1; i < len(vr.Values); i++ {
+		if id, err := cols.get(vr.Values[i], f); err == nil && strings.ToLower(id) == strings.ToLower(scanID) {
+			row = vr.Values[i]
+			break
+		}
+	}
+	// The Sheets API is not 0-index.
+	i++
+	if row == nil {
+		return nil, i, nil, &notFoundError{"user", scanID}
+	}
+	u, err := rowToUser(row, cols)
+	if err != nil {
+		return nil, i, nil, fmt.Errorf("failed to parse user: %v", err)
+	}
+	return u, i, row, nil
+}
+
+// userRangeToUsers converts a *sheets.ValueRange representing the member sheet to user structs.
+// Rows that cannot be parsed are skipped.
+func userRangeToUsers(vr *sheets.ValueRange, cols *columns) []*user {
+	var users []*user
+	// Skip the header row.
+	for i := 1; i < len(vr.Values); i++ {
+		if isEmptyRow(vr.Values[i]) {
+			continue
+		}
+		u, err := rowToUser(vr.Values[i], cols)
+		if err != nil {
+			// The Sheets API is not 0-index.
+			log.Debugf("skipping row %d: failed to parse user: %v", i+1, err)
+			continue
+		}
+		users = append(users, u)
+	}
+	return users
+}
+
+// debtRangeToDebts converts a *sheets.ValueRange representing the debt ledger to debts keyed by BSID.
+// Rows that cannot be parsed are skipped.
+func debtRangeToDebts(vr *sheets.ValueRange) map[string][]debt {
+	debts := make(map[string][]debt)
+	for i, row := range vr.Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		// The Sheets API is not 0-index.
+		d, err := rowToDebt(row, i+1)
+		if err != nil {
+			log.Debugf("skipping debt %d: %v", i+1, err)
+			continue
+		}
+		debts[d.BSID] = append(debts[d.BSID], *d)
+	}
+	return debts
+}
+
+// cardRangeToCards converts a *sheets.ValueRange representing the card history to cards keyed by BSID.
+// Rows that cannot be parsed are skipped.
+func cardRangeToCards(vr *sheets.ValueRange) map[string][]card {
+	cards := make(map[string][]card)
+	for i, row := range vr.Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		// The Sheets API is not 0-index.
+		c, err := rowToCard(row, i+1)
+		if err != nil {
+			log.Debugf("skipping card %d: %v", i+1, err)
+			continue
+		}
+		cards[c.BSID] = append(cards[c.BSID], *c)
+	}
+	return cards
+}
+
+// freezeRangeToFreezes converts a *sheets.ValueRange representing the freezes to freezes keyed by BSID.
+// Rows that cannot be parsed are skipped.
+func freezeRangeToFreezes(vr *sheets.ValueRange) map[string][]freeze {
+	freezes := make(map[string][]freeze)
+	for i, row := range vr.Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		// The Sheets API is not 0-index.
+		f, err := rowToFreeze(row, i+1)
+		if err != nil {
+			log.Debugf("skipping freeze %d: %v", i+1, err)
+			continue
+		}
+		freezes[f.BSID] = append(freezes[f.BSID], *f)
+	}
+	return freezes
+}
+
+// passRangeToPasses converts a *sheets.ValueRange representing the day passes to passes.
+// Rows that cannot be parsed are skipped.
+func passRangeToPasses(vr *sheets.ValueRange) []pass {
+	var passes []pass
+	for i, row := range vr.Values {
+		if isEmptyRow(row) {
+			continue
+		}
+		// The Sheets API is not 0-index.
+		p, err := rowToPass(row, i+1)
+		if err != nil {
+			log.Debugf("skipping pass %d: %v", i+1, err)
+			continue
+		}
+		passes = append(passes, *p)
+	}
+	return passes
+}
+
+// rangeToRow returns the first row of the given A1 notation range, e.g. 7 for "DEBT!A7:E7".
+func rangeToRow(a1 string) (int, error) {
+	cell := a1[strings.LastIndex(a1, "!")+1:]
+	if i := strings.Index(cell, ":"); i != -1 {
+		cell = cell[:i]
+	}
+	n, err := strconv.Atoi(strings.TrimLeft(cell, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the row of range %q", a1)
+	}
+	return n, nil
+}
+
+// rowToVisit converts a row of the visit sheet to a visit struct.
+// The optional third column holds the row of the day pass of the visitor.
+func rowToVisit(row []interface{}) (*visit, error) {
+	if len(row) < 2 {
+		return nil, fmt.Errorf("the given row does not have the right number of fields; expected %d, got %d", 2, len(row))
+	}
+	bsID, ok := row[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse row field %q", "bsid")
+	}
+	ts, ok := row[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse row field %q", "time")
+	}
+	t, err := time.ParseInLocation(time.RFC3339, ts, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse time: %v", err)
+	}
+	v := &visit{BSID: strings.ToLower(bsID), Time: t}
+	if len(row) > 2 {
+		p, ok := row[2].(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse row field %q", "pass")
+		}
+		if p = strings.TrimSpace(p); p != "" {
+			if v.Pass, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("failed to parse pass: %v", err)
+			}
+		}
+	}
+	return v, nil
+}
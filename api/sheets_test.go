@@ -0,0 +1,329 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeSheets is a minimal fake of the Google Sheets API that holds the values of each tab in memory.
+// Ranges without a tab refer to the member tab, like the first tab of a real spreadsheet.
+type fakeSheets struct {
+	sync.Mutex
+	// order holds the titles of the tabs in order; the index of a tab is also its ID.
+	order []string
+	tabs  map[string][][]string
+}
+
+// newFakeSheetsStore returns a Sheets store that is backed by a fake spreadsheet with the given tabs.
+func newFakeSheetsStore(t *testing.T, tabs map[string][][]string) (*sheetsStore, *fakeSheets) {
+	t.Helper()
+	f := &fakeSheets{order: []string{memberTab}, tabs: tabs}
+	for tab := range tabs {
+		if tab != memberTab {
+			f.order = append(f.order, tab)
+		}
+	}
+	sort.Strings(f.order[1:])
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	svc, err := sheets.New(srv.Client())
+	if err != nil {
+		t.Fatalf("failed to create Sheets client: %v", err)
+	}
+	svc.BasePath = srv.URL + "/"
+	return newSheetsStore(client{sheets: svc}, "sheet", nil, nil), f
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (f *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v4/spreadsheets/sheet")
+	var res interface{}
+	var err error
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		ss := new(sheets.Spreadsheet)
+		for i, tab := range f.order {
+			ss.Sheets = append(ss.Sheets, &sheets.Sheet{Properties: &sheets.SheetProperties{Index: int64(i), SheetId: int64(i), Title: tab}})
+		}
+		res = ss
+	case r.Method == http.MethodPost && path == ":batchUpdate":
+		var req sheets.BatchUpdateSpreadsheetRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			err = f.batchUpdate(req.Requests)
+		}
+		res = &sheets.BatchUpdateSpreadsheetResponse{}
+	case r.Method == http.MethodGet && path == "/values:batchGet":
+		vrs := make([]*sheets.ValueRange, len(r.URL.Query()["ranges"]))
+		for i, rng := range r.URL.Query()["ranges"] {
+			if vrs[i], err = f.get(rng); err != nil {
+				break
+			}
+		}
+		res = &sheets.BatchGetValuesResponse{ValueRanges: vrs}
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/values/"):
+		res, err = f.get(strings.TrimPrefix(path, "/values/"))
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/values/") && strings.HasSuffix(path, ":append"):
+		var vr sheets.ValueRange
+		var updated string
+		if err = json.NewDecoder(r.Body).Decode(&vr); err == nil {
+			updated, err = f.append(strings.TrimSuffix(strings.TrimPrefix(path, "/values/"), ":append"), vr.Values)
+		}
+		res = &sheets.AppendValuesResponse{Updates: &sheets.UpdateValuesResponse{UpdatedRange: updated}}
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/values/"):
+		var vr sheets.ValueRange
+		if err = json.NewDecoder(r.Body).Decode(&vr); err == nil {
+			err = f.update(strings.TrimPrefix(path, "/values/"), vr.Values)
+		}
+		res = &sheets.UpdateValuesResponse{}
+	default:
+		http.Error(w, fmt.Sprintf("%s %s is not implemented", r.Method, r.URL.Path), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		// Mimic the error that the Sheets API returns for a range in a missing tab.
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":{"code":400,"message":%q,"status":"INVALID_ARGUMENT"}}`, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// batchUpdate applies the given requests, of which only adding tabs, deleting rows, and clearing rows are implemented.
+func (f *fakeSheets) batchUpdate(reqs []*sheets.Request) error {
+	for _, req := range reqs {
+		switch {
+		case req.AddSheet != nil:
+			if _, ok := f.tabs[req.AddSheet.Properties.Title]; ok {
+				return fmt.Errorf("a tab named %q already exists", req.AddSheet.Properties.Title)
+			}
+			f.order = append(f.order, req.AddSheet.Properties.Title)
+			f.tabs[req.AddSheet.Properties.Title] = nil
+		case req.DeleteDimension != nil:
+			r := req.DeleteDimension.Range
+			tab := f.order[r.SheetId]
+			if int(r.EndIndex) > len(f.tabs[tab]) {
+				return fmt.Errorf("rows %d to %d of %q do not exist", r.StartIndex+1, r.EndIndex, tab)
+			}
+			f.tabs[tab] = append(f.tabs[tab][:r.StartIndex], f.tabs[tab][r.EndIndex:]...)
+		case req.UpdateCells != nil && len(req.UpdateCells.Rows) == 0:
+			r := req.UpdateCells.Range
+			tab := f.order[r.SheetId]
+			for i := r.StartRowIndex; i < r.EndRowIndex && int(i) < len(f.tabs[tab]); i++ {
+				f.tabs[tab][i] = nil
+			}
+		default:
+			return fmt.Errorf("the request is not implemented")
+		}
+	}
+	return nil
+}
+
+// get returns the values in the given range, without trailing empty cells and rows.
+func (f *fakeSheets) get(rng string) (*sheets.ValueRange, error) {
+	tab, first, last, err := f.parse(rng)
+	if err != nil {
+		return nil, err
+	}
+	vr := &sheets.ValueRange{Range: rng, MajorDimension: "ROWS"}
+	for i := first[1]; i < len(f.tabs[tab]) && i <= last[1]; i++ {
+		var row []interface{}
+		for j := first[0]; j < len(f.tabs[tab][i]) && j <= last[0]; j++ {
+			row = append(row, f.tabs[tab][i][j])
+		}
+		for len(row) > 0 && row[len(row)-1] == "" {
+			row = row[:len(row)-1]
+		}
+		vr.Values = append(vr.Values, row)
+	}
+	for len(vr.Values) > 0 && len(vr.Values[len(vr.Values)-1]) == 0 {
+		vr.Values = vr.Values[:len(vr.Values)-1]
+	}
+	return vr, nil
+}
+
+// update writes the given values to the given range.
+func (f *fakeSheets) update(rng string, values [][]interface{}) error {
+	tab, first, _, err := f.parse(rng)
+	if err != nil {
+		return err
+	}
+	for i := range values {
+		for len(f.tabs[tab]) <= first[1]+i {
+			f.tabs[tab] = append(f.tabs[tab], nil)
+		}
+		for j := range values[i] {
+			row := f.tabs[tab][first[1]+i]
+			for len(row) <= first[0]+j {
+				row = append(row, "")
+			}
+			if values[i][j] != nil {
+				row[first[0]+j] = fmt.Sprint(values[i][j])
+			}
+			f.tabs[tab][first[1]+i] = row
+		}
+	}
+	return nil
+}
+
+// append writes the given values to the rows after the last row of the tab of the given range
+// and returns the range that was written.
+func (f *fakeSheets) append(rng string, values [][]interface{}) (string, error) {
+	tab, first, _, err := f.parse(rng)
+	if err != nil {
+		return "", err
+	}
+	vr, err := f.get(fmt.Sprintf("%s!A:Z", tab))
+	if err != nil {
+		return "", err
+	}
+	n := len(vr.Values) + 1
+	if err := f.update(fmt.Sprintf("%s!%c%d:%c%d", tab, 'A'+first[0], n, 'Z', n+len(values)-1), values); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s!A%d:Z%d", tab, n, n+len(values)-1), nil
+}
+
+// parse returns the tab and the zero-based column and row of the first and last cells of the given A1 range.
+func (f *fakeSheets) parse(rng string) (string, [2]int, [2]int, error) {
+	tab := memberTab
+	cells := rng
+	if i := strings.Index(rng, "!"); i >= 0 {
+		tab, cells = rng[:i], rng[i+1:]
+	}
+	if _, ok := f.tabs[tab]; !ok {
+		return "", [2]int{}, [2]int{}, fmt.Errorf("Unable to parse range: %s", rng)
+	}
+	parts := strings.SplitN(cells, ":", 2)
+	if len(parts) != 2 {
+		return "", [2]int{}, [2]int{}, fmt.Errorf("Unable to parse range: %s", rng)
+	}
+	cell := func(s string, row int) [2]int {
+		col := 0
+		for len(s) > 0 && s[0] >= 'A' && s[0] <= 'Z' {
+			col = col*26 + int(s[0]-'A') + 1
+			s = s[1:]
+		}
+		if n, err := strconv.Atoi(s); err == nil {
+			row = n - 1
+		}
+		return [2]int{col - 1, row}
+	}
+	return tab, cell(parts[0], 0), cell(parts[1], int(^uint(0)>>1)), nil
+}
+
+func TestSheetsUpdateUserKeepsExpiration(t *testing.T) {
+	expiration := midnight(time.Now()).AddDate(0, 1, 0)
+	for _, tc := range []struct {
+		name   string
+		update func(t *testing.T, s *sheetsStore)
+	}{
+		{
+			name: "credit",
+			update: func(t *testing.T, s *sheetsStore) {
+				u, err := s.UserByBSID(context.Background(), "a")
+				if err != nil {
+					t.Fatalf("failed to get user: %v", err)
+				}
+				if _, err := newTestAPI(s).useCredit(context.Background(), s, u); err != nil {
+					t.Fatalf("failed to use credit: %v", err)
+				}
+			},
+		},
+		{
+			name: "replacement card",
+			update: func(t *testing.T, s *sheetsStore) {
+				serve(t, newTestAPI(s).retireCardHandler(cardLost), newTestRequest(t, http.MethodPost, map[string]string{"id": "a", "rfid": "c1"}, map[string]string{"replacement": "c2"}))
+				u, err := s.UserByBSID(context.Background(), "a")
+				if err != nil {
+					t.Fatalf("failed to get user: %v", err)
+				}
+				if u.ID != "c2" {
+					t.Errorf("expected card %q, got %q", "c2", u.ID)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newFakeSheetsStore(t, map[string][][]string{
+				memberTab: {
+					{"BSID", "Expiration", "Name", "Email", "RFID", "Credits"},
+					{"a", expiration.Format(dateFormat), "A", "a@example.com", "c1", "10"},
+				},
+				cardTab:   nil,
+				debtTab:   nil,
+				freezeTab: nil,
+			})
+			tc.update(t, s)
+			u, err := s.UserByBSID(context.Background(), "a")
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			if !u.Expiration.Equal(expiration) {
+				t.Errorf("expected expiration %s, got %s", expiration.Format(dateFormat), u.Expiration.Format(dateFormat))
+			}
+		})
+	}
+}
+
+func TestSheetsMissingTabs(t *testing.T) {
+	ctx := context.Background()
+	s, f := newFakeSheetsStore(t, map[string][][]string{
+		memberTab: {
+			{"BSID", "Expiration", "Name", "Email", "RFID"},
+			{"a", midnight(time.Now()).AddDate(0, 1, 0).Format(dateFormat), "A", "a@example.com", "c1"},
+		},
+	})
+	ros, err := s.Roster(ctx)
+	if err != nil {
+		t.Fatalf("failed to read roster: %v", err)
+	}
+	if len(ros.users) != 1 {
+		t.Errorf("expected 1 member, got %d", len(ros.users))
+	}
+	if _, err := s.CardByRFID(ctx, "c1"); err == nil {
+		t.Error("expected the card to have no history")
+	} else if _, ok := err.(*notFoundError); !ok {
+		t.Errorf("failed to find card: %v", err)
+	}
+	if _, err := s.Freezes(ctx, "a"); err != nil {
+		t.Errorf("failed to list freezes: %v", err)
+	}
+	if _, err := s.Cards(ctx, "a"); err != nil {
+		t.Errorf("failed to list cards: %v", err)
+	}
+	if _, err := s.ArchivedUsers(ctx); err != nil {
+		t.Errorf("failed to list archived users: %v", err)
+	}
+	if _, err := s.snapshot(ctx); err != nil {
+		t.Errorf("failed to read snapshot: %v", err)
+	}
+	d := debt{BSID: "a", Amount: 10, Created: midnight(time.Now())}
+	if err := s.AddDebt(ctx, &d); err != nil {
+		t.Fatalf("failed to add debt: %v", err)
+	}
+	if len(f.tabs[debtTab]) != 1 {
+		t.Errorf("expected the debt tab to be added with 1 row, got %d rows", len(f.tabs[debtTab]))
+	}
+	debts, err := s.Debts(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to list debts: %v", err)
+	}
+	if len(debts) != 1 || debts[0].ID != d.ID {
+		t.Errorf("expected debt %d, got %v", d.ID, debts)
+	}
+}
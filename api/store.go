@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Store is the interface implemented by the backends that persist
+// Berlin Strength members.
+type Store interface {
+	// Users returns all of the users, in no particular order.
+	Users(ctx context.Context) ([]*user, error)
+	// UserByBSID returns the user with the given Berlin Strength ID.
+	UserByBSID(ctx context.Context, bsID string) (*user, error)
+	// UserByRFID returns the user to whom the given RFID card belongs.
+	UserByRFID(ctx context.Context, rfid string) (*user, error)
+	// UserByEmail returns the user with the given email address.
+	UserByEmail(ctx context.Context, email string) (*user, error)
+	// CreateUser adds a new user.
+	CreateUser(ctx context.Context, u *user) error
+	// CreateUsers adds the given users in a single batch;
+	// if any of them cannot be added, none of them are.
+	CreateUsers(ctx context.Context, users []*user) error
+	// UpdateUser updates the user with the given BSID.
+	// Empty fields of the given user keep their existing values.
+	UpdateUser(ctx context.Context, bsID string, u *user) error
+	// ArchiveUser moves the user with the given BSID from the members into the archive.
+	// Everything else that refers to the user, e.g. their visits, is kept.
+	ArchiveUser(ctx context.Context, bsID string) error
+	// ArchivedUsers returns all of the users in the archive, in no particular order.
+	ArchivedUsers(ctx context.Context) ([]*user, error)
+	// DeleteUser removes the user with the given BSID, whether or not they are archived,
+	// along with all of their visits, cards, debts, and freezes and returns the removed user.
+	DeleteUser(ctx context.Context, bsID string) (*user, error)
+	// ReassignCard makes the given card the card of the user with the given BSID,
+	// clearing it from any other user who holds it.
+	ReassignCard(ctx context.Context, rfid, bsID string) error
+	// Debts returns the debt ledger of the user with the given BSID.
+	Debts(ctx context.Context, bsID string) ([]debt, error)
+	// AddDebt adds an entry to the debt ledger and sets its ID.
+	AddDebt(ctx context.Context, d *debt) error
+	// SettleDebt marks the debt with the given ID of the user with the given BSID as settled.
+	SettleDebt(ctx context.Context, bsID string, id int) error
+	// Freezes returns the freezes of the user with the given BSID.
+	Freezes(ctx context.Context, bsID string) ([]freeze, error)
+	// AddFreeze adds a freeze and sets its ID.
+	AddFreeze(ctx context.Context, f *freeze) error
+	// EndFreeze ends the freeze with the given ID of the user with the given BSID on the given day.
+	EndFreeze(ctx context.Context, bsID string, id int, end time.Time) error
+	// Cards returns the card history of the user with the given BSID.
+	Cards(ctx context.Context, bsID string) ([]card, error)
+	// CardByRFID returns the most recent entry in the card history for the given card.
+	CardByRFID(ctx context.Context, rfid string) (*card, error)
+	// AddCard adds an entry to the card history and sets its ID.
+	AddCard(ctx context.Context, c *card) error
+	// Plans returns the catalog of membership plans.
+	Plans(ctx context.Context) ([]plan, error)
+	// CreatePlan adds a plan to the catalog.
+	CreatePlan(ctx context.Context, p *plan) error
+	// Passes returns all of the day passes.
+	Passes(ctx context.Context) ([]pass, error)
+	// CreatePass adds a day pass and sets its ID.
+	CreatePass(ctx context.Context, p *pass) error
+	// Roster returns an in-memory copy of all of the users along with their cards, debts, and freezes.
+	// The roster may be shared and must not be modified.
+	Roster(ctx context.Context) (*roster, error)
+	// Visits returns all of the recorded visits in the order in which they were recorded.
+	Visits(ctx context.Context) ([]visit, error)
+	// RecordVisit records the given visit by a member or by the holder of a day pass.
+	RecordVisit(ctx context.Context, v *visit) error
+}
+
+// snapshot holds all of the data kept by a Store.
+type snapshot struct {
+	// Archived holds the users who are no longer members.
+	Archived []*user
+	Cards    []card
+	Debts    []debt
+	Freezes  []freeze
+	Passes   []pass
+	Plans    []plan
+	Users    []*user
+	Visits   []visit
+}
+
+// loader is implemented by stores that can load a snapshot of
+// another store, e.g. to migrate a gym off of Google Sheets.
+type loader interface {
+	load(ctx context.Context, s *snapshot) error
+}
+
+// findUser will look for a user in the given store by either BSID or RFID and return a pointer to the user with their cards, debts, and freezes populated.
+func findUser(ctx context.Context, s Store, scanID string, byRFID bool) (*user, error) {
+	var u *user
+	var err error
+	if byRFID {
+		u, err = s.UserByRFID(ctx, scanID)
+	} else {
+		u, err = s.UserByBSID(ctx, scanID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	debts, err := s.Debts(ctx, u.BSID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get debts: %v", err)
+	}
+	setDebts(u, debts)
+	freezes, err := s.Freezes(ctx, u.BSID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get freezes: %v", err)
+	}
+	setFreezes(u, freezes, time.Now())
+	if u.Cards, err = s.Cards(ctx, u.BSID); err != nil {
+		return nil, fmt.Errorf("failed to get cards: %v", err)
+	}
+	log.Infof("found email %q for %q", u.Email, scanID)
+	return u, nil
+}
+
+// checkUnique returns a *conflictError if the BSID, card, or email of the given user
+// already belong to any member other than the one with the given BSID.
+// Empty fields are not checked, so the same check works for new and updated users.
+func checkUnique(ctx context.Context, s Store, u *user, bsID string) error {
+	t, err := newTakenIDs(ctx, s, true)
+	if err != nil {
+		return err
+	}
+	return t.check(u, bsID)
+}
+
+// takenIDs holds the members, archived members, and card history that
+// the BSIDs, emails, and cards of new and updated members must not clash with.
+type takenIDs struct {
+	ros *roster
+	// archived holds the archived members by BSID; their BSIDs stay taken, since their visits still refer to them.
+	archived map[string]*user
+	// active holds the most recent entry in the card history for each card.
+	active map[string]card
+}
+
+// newTakenIDs reads the taken IDs from the given store; the archive is only read if archived is true.
+func newTakenIDs(ctx context.Context, s Store, archived bool) (*takenIDs, error) {
+	ros, err := s.Roster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the existing members: %v", err)
+	}
+	var cards []card
+	for _, cs := range ros.cards {
+		cards = append(cards, cs...)
+	}
+	t := &takenIDs{ros: ros, archived: make(map[string]*user), active: latestCards(cards)}
+	if !archived {
+		return t, nil
+	}
+	users, err := s.ArchivedUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the archived members: %v", err)
+	}
+	for _, u := range users {
+		t.archived[u.BSID] = u
+	}
+	return t, nil
+}
+
+// check returns a *conflictError if the BSID, card, or email of the given user
+// are taken by any member other than the one with the given BSID.
+func (t *takenIDs) check(u *user, bsID string) error {
+	bsID = strings.ToLower(bsID)
+	if id := strings.ToLower(u.BSID); id != "" && id != bsID {
+		if existing := t.ros.byBSID[id]; existing != nil {
+			return &conflictError{"BSID", id, existing}
+		}
+		if archived := t.archived[id]; archived != nil {
+			return &conflictError{"BSID", id, archived}
+		}
+	}
+	if email := strings.ToLower(u.Email); email != "" {
+		if existing := t.ros.byEmail[email]; existing != nil && existing.BSID != bsID {
+			return &conflictError{"email", email, existing}
+		}
+	}
+	if u.ID == "" {
+		return nil
+	}
+	return t.checkCard(u.ID, bsID)
+}
+
+// checkCard returns a *conflictError if the given card is in use by any member other than the one with the given BSID.
+func (t *takenIDs) checkCard(rfid, bsID string) error {
+	rfid, bsID = strings.ToLower(rfid), strings.ToLower(bsID)
+	if u := t.ros.byRFID[rfid]; u != nil && u.BSID != bsID {
+		return &conflictError{"card", rfid, u}
+	}
+	c, ok := t.active[rfid]
+	if !ok || c.Status != cardActive || c.BSID == bsID {
+		return nil
+	}
+	u := t.ros.byBSID[c.BSID]
+	if u == nil {
+		u = &user{BSID: c.BSID}
+	}
+	return &conflictError{"card", rfid, u}
+}
+
+// mergeUser copies the non-empty fields of src into dst.
+func mergeUser(dst, src *user) {
+	if src.BSID != "" {
+		dst.BSID = src.BSID
+	}
+	if src.Credits != nil {
+		c := *src.Credits
+		dst.Credits = &c
+	}
+	if src.Email != "" {
+		dst.Email = src.Email
+	}
+	if !src.Expiration.IsZero() {
+		dst.Expiration = src.Expiration
+	}
+	if src.ID != "" {
+		dst.ID = src.ID
+	}
+	if src.Name != "" {
+		dst.Name = src.Name
+	}
+	if src.Photo != "" {
+		dst.Photo = src.Photo
+	}
+	if src.Plan != "" {
+		dst.Plan = src.Plan
+	}
+}
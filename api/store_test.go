@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDeleteUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "berlinstrength")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name  string
+		store func(t *testing.T) (Store, error)
+	}{
+		{
+			name:  "memory",
+			store: func(_ *testing.T) (Store, error) { return NewMemoryStore(), nil },
+		},
+		{
+			name:  "bolt",
+			store: func(_ *testing.T) (Store, error) { return NewBoltStore(filepath.Join(dir, "bolt.db")) },
+		},
+		{
+			name: "sheets",
+			store: func(t *testing.T) (Store, error) {
+				s, _ := newFakeSheetsStore(t, map[string][][]string{
+					memberTab: {{"BSID", "Expiration", "Name", "Email", "RFID"}},
+					visitTab:  nil,
+					cardTab:   nil,
+					debtTab:   nil,
+					freezeTab: nil,
+				})
+				return s, nil
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := tc.store(t)
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			now := midnight(time.Now())
+			for _, bsID := range []string{"a", "b"} {
+				if err := s.CreateUser(ctx, &user{BSID: bsID, ID: "c" + bsID, Expiration: now}); err != nil {
+					t.Fatalf("failed to create user: %v", err)
+				}
+				if err := s.AddCard(ctx, &card{BSID: bsID, RFID: "old" + bsID, Status: cardRetired, Changed: now}); err != nil {
+					t.Fatalf("failed to add card: %v", err)
+				}
+				if err := s.AddDebt(ctx, &debt{BSID: bsID, Amount: 1000, Created: now}); err != nil {
+					t.Fatalf("failed to add debt: %v", err)
+				}
+				if err := s.AddFreeze(ctx, &freeze{BSID: bsID, Start: now}); err != nil {
+					t.Fatalf("failed to add freeze: %v", err)
+				}
+				if err := s.RecordVisit(ctx, &visit{BSID: bsID, Time: now}); err != nil {
+					t.Fatalf("failed to record visit: %v", err)
+				}
+			}
+			before, err := s.Debts(ctx, "b")
+			if err != nil {
+				t.Fatalf("failed to list debts: %v", err)
+			}
+			if _, err := s.DeleteUser(ctx, "a"); err != nil {
+				t.Fatalf("failed to delete user: %v", err)
+			}
+			for _, tc := range []struct {
+				bsID     string
+				expected int
+			}{
+				{"a", 0},
+				{"b", 1},
+			} {
+				cards, err := s.Cards(ctx, tc.bsID)
+				if err != nil {
+					t.Fatalf("failed to list cards: %v", err)
+				}
+				debts, err := s.Debts(ctx, tc.bsID)
+				if err != nil {
+					t.Fatalf("failed to list debts: %v", err)
+				}
+				freezes, err := s.Freezes(ctx, tc.bsID)
+				if err != nil {
+					t.Fatalf("failed to list freezes: %v", err)
+				}
+				if len(cards) != tc.expected || len(debts) != tc.expected || len(freezes) != tc.expected {
+					t.Errorf("expected %d cards, debts, and freezes of %q, got %d, %d, and %d", tc.expected, tc.bsID, len(cards), len(debts), len(freezes))
+				}
+			}
+			// The entries of the other users must keep their IDs.
+			if debts, err := s.Debts(ctx, "b"); err != nil || len(debts) != 1 || debts[0].ID != before[0].ID {
+				t.Errorf("expected the debt of %q to keep the ID %d, got %v: %v", "b", before[0].ID, debts, err)
+			}
+			if _, err := s.CardByRFID(ctx, "olda"); err == nil {
+				t.Error("expected the cards of the deleted user to be gone")
+			}
+			visits, err := s.Visits(ctx)
+			if err != nil {
+				t.Fatalf("failed to list visits: %v", err)
+			}
+			if len(visits) != 1 || visits[0].BSID != "b" {
+				t.Errorf("expected only the visit of %q to be left, got %v", "b", visits)
+			}
+		})
+	}
+}
+
+func TestCheckUnique(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		u    user
+		// bsID is the BSID of the member being updated, or empty for a new member.
+		bsID  string
+		field string
+	}{
+		{name: "new member", u: user{BSID: "n", Email: "n@example.com", ID: "c9"}},
+		{name: "existing BSID", u: user{BSID: "A"}, field: "BSID"},
+		{name: "archived BSID", u: user{BSID: "old"}, field: "BSID"},
+		{name: "archived BSID on update", u: user{BSID: "old"}, bsID: "a", field: "BSID"},
+		{name: "own BSID on update", u: user{BSID: "a"}, bsID: "a"},
+		{name: "existing email", u: user{BSID: "n", Email: "A@example.com"}, field: "email"},
+		{name: "own email on update", u: user{Email: "a@example.com"}, bsID: "a"},
+		{name: "existing card", u: user{BSID: "n", ID: "C1"}, field: "card"},
+		{name: "retired card", u: user{BSID: "n", ID: "r1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t, user{BSID: "a", Email: "a@example.com", ID: "c1"}, user{BSID: "old", ID: "r1"})
+			if err := s.AddCard(ctx, &card{BSID: "old", RFID: "r1", Status: cardRetired, Changed: midnight(time.Now())}); err != nil {
+				t.Fatalf("failed to add card: %v", err)
+			}
+			if err := s.ArchiveUser(ctx, "old"); err != nil {
+				t.Fatalf("failed to archive user: %v", err)
+			}
+			var field string
+			err := checkUnique(ctx, s, &tc.u, tc.bsID)
+			if c, ok := err.(*conflictError); ok {
+				field = c.field
+			} else if err != nil {
+				t.Fatalf("failed to check user: %v", err)
+			}
+			if field != tc.field {
+				t.Errorf("expected a conflict in field %q, got %q", tc.field, field)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "berlinstrength")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	now := midnight(time.Now())
+	snap := &snapshot{
+		Users:  []*user{{BSID: "a", ID: "c1", Expiration: now}},
+		Cards:  []card{{BSID: "a", RFID: "c0", Status: cardRetired, Changed: now}},
+		Debts:  []debt{{BSID: "a", Amount: 1000, Created: now}},
+		Visits: []visit{{BSID: "a", Time: now}},
+	}
+	for _, tc := range []struct {
+		name  string
+		store func() (Store, error)
+	}{
+		{
+			name:  "memory",
+			store: func() (Store, error) { return NewMemoryStore(), nil },
+		},
+		{
+			name:  "bolt",
+			store: func() (Store, error) { return NewBoltStore(filepath.Join(dir, "load.db")) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, err := tc.store()
+			if err != nil {
+				t.Fatalf("failed to open store: %v", err)
+			}
+			if err := s.(loader).load(ctx, snap); err != nil {
+				t.Fatalf("failed to load snapshot: %v", err)
+			}
+			// Loading the same snapshot again must not duplicate its entries.
+			if err := s.(loader).load(ctx, snap); err == nil {
+				t.Error("expected loading into a store that is not empty to fail")
+			} else if _, ok := err.(*notEmptyError); !ok {
+				t.Errorf("expected a *notEmptyError, got %v", err)
+			}
+			cards, err := s.Cards(ctx, "a")
+			if err != nil {
+				t.Fatalf("failed to list cards: %v", err)
+			}
+			debts, err := s.Debts(ctx, "a")
+			if err != nil {
+				t.Fatalf("failed to list debts: %v", err)
+			}
+			visits, err := s.Visits(ctx)
+			if err != nil {
+				t.Fatalf("failed to list visits: %v", err)
+			}
+			if len(cards) != 1 || len(debts) != 1 || len(visits) != 1 {
+				t.Errorf("expected 1 card, debt, and visit, got %d, %d, and %d", len(cards), len(debts), len(visits))
+			}
+		})
+	}
+}
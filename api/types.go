@@ -7,7 +7,9 @@ import (
 	"net/mail"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/squat/berlinstrength/rfid"
@@ -19,15 +21,7 @@ import (
 )
 
 const (
-	bsIDColumn       = 0
-	expirationColumn = 1
-	nameColumn       = 2
-	emailColumn      = 5
-	photoColumn      = 10
-	rfidColumn       = 9
-	minRowLength     = nameColumn + 1
-	defaultRowLength = 11
-	dateFormat       = "02/01/2006"
+	dateFormat = "02/01/2006"
 )
 
 // notFoundError is the error type returned when a resource is not found.
@@ -42,16 +36,78 @@ func (e *notFoundError) Error() string {
 	return fmt.Sprintf("%s %q was not found", e.resource, e.id)
 }
 
+// notEmptyError is the error type returned when a snapshot is loaded into a store that already holds data,
+// since the entries of both would otherwise be mixed up or duplicated.
+type notEmptyError struct {
+	resource string
+}
+
+// Error implements the error interface.
+func (e *notEmptyError) Error() string {
+	return fmt.Sprintf("the store already holds %s; sheets can only be imported into an empty store", e.resource)
+}
+
+// conflictError is the error type returned when a value that must be unique
+// already belongs to another member.
+type conflictError struct {
+	field string
+	value string
+	user  *user
+}
+
+// Error implements the error interface.
+func (e *conflictError) Error() string {
+	return fmt.Sprintf("%s %q already belongs to member %q", e.field, e.value, e.user.BSID)
+}
+
 // Config represents the configuration for the Berlin Strength API.
 type Config struct {
+	// Time for which the visits read for attendance analytics are cached;
+	// if zero, every request reads them
+	AnalyticsCache time.Duration
+	// Number of people who may be inside at once; if zero, there is no limit
+	Capacity int
+	// Time after checking in during which people count as inside unless they check out;
+	// if zero, occupancy is not tracked
+	CheckOutWindow time.Duration
 	// OAuth ID
 	ClientID string
 	// OAuth secret
 	ClientSecret string
+	// Interval during which repeat scans of the same card are treated as duplicates
+	// and do not record visits; if zero, every scan records a visit
+	DuplicateWindow time.Duration
 	// Allowed emails
 	Emails []string
+	// File descriptor for the RFID scanner at the exit, whose scans check out;
+	// if nil, tapping a card at the entrance while inside checks out
+	ExitFile *os.File
 	// File descriptor for the RFID scanner; defaults to os.Stdin
 	File *os.File
+	// Number of days after a membership expires during which scans are allowed with a warning
+	GraceDays int
+	// Headers of the columns of the member sheet, keyed by member field;
+	// fields that are not given use their default headers
+	Headers map[string]string
+	// Name of the location of the RFID scanner, by which check-ins are counted
+	Location string
+	// Interval at which to count the members of the sheets in use for the metrics;
+	// if zero, members are not counted
+	MetricsRefresh time.Duration
+	// Path to the journal of visits that failed to be recorded; visits that can never be recorded
+	// are moved to the same path with the suffix ".failed"; if empty, failed visits are only retried from memory
+	Journal string
+	// Interval at which to refresh the cached rosters of the sheets in use;
+	// if zero, every scan reads the sheet
+	RosterRefresh time.Duration
+	// Effects of the reasons for which a scan may be refused, keyed by reason;
+	// reasons that are not given use their default effects
+	Rules map[string]string
+	// Number of days before a membership expires from which scans warn that it is expiring soon
+	SoonDays int
+	// Store in which to persist members; if nil, the Google Sheet selected
+	// by each session is used
+	Store Store
 	// URL at which to listen
 	URL *url.URL
 }
@@ -60,14 +116,29 @@ type Config struct {
 type API struct {
 	clients    map[string]client
 	config     *Config
+	credits    sync.Mutex
 	done       <-chan struct{}
+	exitRFID   rfid.RFID
 	handleRFID func(string)
 	hub        *websocket.Hub
 	mux        http.Handler
+	occupancy  *occupancy
+	policy     *policy
+	queue      *visitQueue
 	rfid       rfid.RFID
+	rosters    *rosterCache
+	scans      *scanTracker
+	sessions   sync.RWMutex
 	sheets     map[string]string
+	taps       *scanTracker
+	visits     *visitCache
 
-	rfidScansTotal *prometheus.CounterVec
+	checkInsTotal        *prometheus.CounterVec
+	members              *prometheus.GaugeVec
+	membersExpiring      *prometheus.GaugeVec
+	rfidScanReasonsTotal *prometheus.CounterVec
+	rfidScansTotal       *prometheus.CounterVec
+	visitQueueDepth      prometheus.Gauge
 }
 
 type client struct {
@@ -91,13 +162,28 @@ func newClient(oauthClient *http.Client) (*client, error) {
 }
 
 type user struct {
-	BSID       string    `json:"bsID"`
-	Debt       bool      `json:"debt"`
-	Email      string    `json:"email"`
-	Expiration time.Time `json:"expiration"`
-	ID         string    `json:"id"`
-	Name       string    `json:"name"`
-	Photo      string    `json:"photo"`
+	BSID string `json:"bsID"`
+	// Cards is the history of the cards of the member, other than the card in the RFID column.
+	Cards []card `json:"cards"`
+	// Credits is the number of visits left on a punch card;
+	// it is nil for members whose membership is not limited by visits.
+	Credits   *int   `json:"credits"`
+	Debt      bool   `json:"debt"`
+	DebtTotal int64  `json:"debtTotal"`
+	Debts     []debt `json:"debts"`
+	Email     string `json:"email"`
+	// EffectiveExpiration is the expiration extended by the time the membership was frozen.
+	EffectiveExpiration time.Time `json:"effectiveExpiration"`
+	Expiration          time.Time `json:"expiration"`
+	Freezes             []freeze  `json:"freezes"`
+	Frozen              bool      `json:"frozen"`
+	ID                  string    `json:"id"`
+	// LastVisit is the time of the most recent visit of the member;
+	// it is only set by listings that read the visits.
+	LastVisit *time.Time `json:"lastVisit,omitempty"`
+	Name      string     `json:"name"`
+	Photo     string     `json:"photo"`
+	Plan      string     `json:"plan"`
 }
 
 func (u *user) UnmarshalJSON(b []byte) error {
@@ -129,6 +215,16 @@ func (u *user) UnmarshalJSON(b []byte) error {
 	if _, ok := m["photo"].(string); ok {
 		u.Photo = m["photo"].(string)
 	}
+	if _, ok := m["plan"].(string); ok {
+		u.Plan = m["plan"].(string)
+	}
+	if _, ok := m["credits"].(float64); ok {
+		c := int(m["credits"].(float64))
+		if float64(c) != m["credits"].(float64) || c < 0 {
+			return fmt.Errorf("credits must be a non-negative integer, got %v", m["credits"])
+		}
+		u.Credits = &c
+	}
 	var expiration time.Time
 	if _, ok := m["expiration"].(string); ok {
 		if m["expiration"].(string) != "" {
@@ -142,69 +238,150 @@ func (u *user) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func rowToUser(row []interface{}) (*user, error) {
-	if len(row) < minRowLength {
-		return nil, fmt.Errorf("the given row does not have the right number of fields; expected at least %d, got %d", minRowLength, len(row))
+func rowToUser(row []interface{}, cols *columns) (*user, error) {
+	for _, f := range requiredFields {
+		if i := cols.index[f]; i >= len(row) {
+			return nil, fmt.Errorf("the given row does not have the right number of fields; expected at least %d, got %d", i+1, len(row))
+		}
 	}
-	bsID, ok := row[bsIDColumn].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to parse row field %q", "bsid")
+	bsID, err := cols.get(row, bsIDField)
+	if err != nil {
+		return nil, err
 	}
-	eDate, ok := row[expirationColumn].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to parse row field %q", "expiration date")
+	eDate, err := cols.get(row, expirationField)
+	if err != nil {
+		return nil, err
 	}
 	expiration, err := time.ParseInLocation(dateFormat, eDate, loc)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse expiration as date %v", err)
 	}
-	name, ok := row[nameColumn].(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to parse row field %q", "name")
+	name, err := cols.get(row, nameField)
+	if err != nil {
+		return nil, err
 	}
-	// email, photo, and rfid must be checked conditionally since they are exceptions to the format.
-	var email string
-	if len(row) > emailColumn {
-		email, ok = row[emailColumn].(string)
-		if !ok {
-			return nil, fmt.Errorf("failed to parse row field %q", "email")
-		}
+	// Credits, email, photo, plan, and rfid are optional, so they are empty if the sheet or row does not have them.
+	email, err := cols.get(row, emailField)
+	if err != nil {
+		return nil, err
 	}
-	var photo string
-	if len(row) > photoColumn {
-		photo, ok = row[photoColumn].(string)
-		if !ok {
-			return nil, fmt.Errorf("failed to parse row field %q", "photo")
-		}
+	photo, err := cols.get(row, photoField)
+	if err != nil {
+		return nil, err
 	}
-	var id string
-	if len(row) > rfidColumn {
-		id, ok = row[rfidColumn].(string)
-		if !ok {
-			return nil, fmt.Errorf("failed to parse row field %q", "id")
-		}
+	plan, err := cols.get(row, planField)
+	if err != nil {
+		return nil, err
+	}
+	id, err := cols.get(row, rfidField)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cols.get(row, creditsField)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := parseCredits(c)
+	if err != nil {
+		return nil, err
 	}
 	return &user{
 		BSID:       strings.ToLower(bsID),
+		Credits:    credits,
 		Email:      strings.ToLower(email),
 		Expiration: expiration,
 		ID:         strings.ToLower(id),
 		Name:       name,
 		Photo:      photo,
+		Plan:       plan,
 	}, nil
 }
 
-func userToRow(u *user) []interface{} {
-	r := make([]interface{}, defaultRowLength)
-	r[bsIDColumn] = strings.ToLower(u.BSID)
-	r[expirationColumn] = u.Expiration.Format(dateFormat)
-	r[nameColumn] = u.Name
-	r[emailColumn] = strings.ToLower(u.Email)
-	r[photoColumn] = u.Photo
-	r[rfidColumn] = strings.ToLower(u.ID)
+func userToRow(u *user, cols *columns) []interface{} {
+	r := make([]interface{}, cols.width)
+	cols.set(r, bsIDField, strings.ToLower(u.BSID))
+	if !u.Expiration.IsZero() {
+		cols.set(r, expirationField, u.Expiration.Format(dateFormat))
+	}
+	cols.set(r, nameField, u.Name)
+	cols.set(r, emailField, strings.ToLower(u.Email))
+	cols.set(r, photoField, u.Photo)
+	cols.set(r, planField, u.Plan)
+	cols.set(r, rfidField, strings.ToLower(u.ID))
+	if u.Credits != nil {
+		cols.set(r, creditsField, strconv.Itoa(*u.Credits))
+	}
 	return r
 }
 
+// parseCredits parses the visit credits of a member; an empty value means the member has no punch card.
+func parseCredits(s string) (*int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	c, err := strconv.Atoi(s)
+	if err != nil || c < 0 {
+		return nil, fmt.Errorf("failed to parse credits %q as a non-negative integer", s)
+	}
+	return &c, nil
+}
+
+// scanStatus describes the state of a membership at the time of a scan.
+type scanStatus string
+
+const (
+	statusValid   scanStatus = "valid"
+	statusExpired scanStatus = "expired"
+	statusFrozen  scanStatus = "frozen"
+)
+
+// scanEvent is the message broadcast to clients when a card is scanned.
+// If the card does not belong to any member, the event has no user and holds an error instead.
+type scanEvent struct {
+	*user
+	// Card is the scanned card if it was lost or retired.
+	Card *card `json:"card,omitempty"`
+	// CheckOut is true if the card was tapped by someone inside, who is now checked out.
+	CheckOut bool     `json:"checkOut,omitempty"`
+	Decision decision `json:"decision"`
+	// Duplicate is true if the card was already scanned recently, in which case no visit is recorded.
+	Duplicate bool   `json:"duplicate"`
+	Error     string `json:"error,omitempty"`
+	// Pass is the day pass to which the scanned card is bound, if it does not belong to a member.
+	Pass *pass `json:"pass,omitempty"`
+	// ExpiresIn is the number of days until the membership expires; it is negative for expired memberships.
+	ExpiresIn int `json:"expiresIn"`
+	// Owner is the name of the former owner of a lost or retired card.
+	Owner   string     `json:"owner,omitempty"`
+	Reasons []reason   `json:"reasons"`
+	Status  scanStatus `json:"status,omitempty"`
+}
+
+// newScanEvent returns the scan event for the given user at the given time.
+func newScanEvent(u *user, p *policy, duplicate bool, now time.Time) scanEvent {
+	e := scanEvent{user: u, Duplicate: duplicate, ExpiresIn: expiresIn(u, now), Status: statusValid}
+	switch {
+	case u.Frozen:
+		e.Status = statusFrozen
+	case !now.Before(u.EffectiveExpiration):
+		e.Status = statusExpired
+	}
+	reasons := p.conditions(u, now)
+	if duplicate {
+		reasons = append(reasons, reasonPassback)
+	}
+	e.Decision, e.Reasons = p.decide(reasons)
+	return e
+}
+
+// visit is a check-in by a member or, if the pass is set, by the holder of a day pass.
+type visit struct {
+	BSID string    `json:"bsID"`
+	Pass int       `json:"pass,omitempty"`
+	Time time.Time `json:"time"`
+}
+
 type initialState struct {
 	Client      user    `json:"client"`
 	Email       string  `json:"email"`
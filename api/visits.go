@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// visitSummary sums up the visits of a member.
+type visitSummary struct {
+	Total     int        `json:"total"`
+	ThisMonth int        `json:"thisMonth"`
+	LastVisit *time.Time `json:"lastVisit,omitempty"`
+	// PerWeek is the average number of visits per week since the first visit.
+	PerWeek float64 `json:"perWeek"`
+}
+
+// summarizeVisits sums up the given visits of a member at the given time; the months are those of Berlin.
+func summarizeVisits(visits []visit, now time.Time) visitSummary {
+	var s visitSummary
+	if len(visits) == 0 {
+		return s
+	}
+	now = now.In(loc)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	first := visits[0].Time
+	for i, v := range visits {
+		s.Total++
+		if !v.Time.Before(month) {
+			s.ThisMonth++
+		}
+		if s.LastVisit == nil || v.Time.After(*s.LastVisit) {
+			s.LastVisit = &visits[i].Time
+		}
+		if v.Time.Before(first) {
+			first = v.Time
+		}
+	}
+	// Members who started visiting less than a week ago have visited as often as they did in their first week.
+	weeks := float64(days(first, now)) / 7
+	if weeks < 1 {
+		weeks = 1
+	}
+	s.PerWeek = float64(s.Total) / weeks
+	return s
+}
+
+// memberVisits returns the given visits of the member with the given BSID, most recent first.
+func memberVisits(visits []visit, bsID string) []visit {
+	var mine []visit
+	for _, v := range visits {
+		if v.BSID == bsID {
+			mine = append(mine, v)
+		}
+	}
+	sort.SliceStable(mine, func(i, j int) bool { return mine[i].Time.After(mine[j].Time) })
+	return mine
+}
+
+// getVisitsHandler allows the client to get the visits of a user, most recent first, along with a summary of all of them.
+// The visits are filtered by the from and to query parameters, which are inclusive days,
+// and paged with the limit and offset query parameters.
+func (a *API) getVisitsHandler(w http.ResponseWriter, r *http.Request) {
+	bsID := strings.ToLower(mux.Vars(r)["id"])
+	from, err := queryDate(r, "from")
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	to, err := queryDate(r, "to")
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	limit, err := pageSize(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	s, err := a.storeFromSession(r)
+	if err != nil {
+		writeJSONError(err, http.StatusBadRequest).ServeHTTP(w, r)
+		return
+	}
+	all, err := s.Visits(r.Context())
+	if err != nil {
+		log.Errorf("failed to list visits: %v", err)
+		writeJSONError(err, http.StatusInternalServerError).ServeHTTP(w, r)
+		return
+	}
+	visits := memberVisits(all, bsID)
+	summary := summarizeVisits(visits, time.Now())
+	filtered := visits[:0:0]
+	for _, v := range visits {
+		if (from.IsZero() || !v.Time.Before(from)) && (to.IsZero() || v.Time.Before(to.AddDate(0, 0, 1))) {
+			filtered = append(filtered, v)
+		}
+	}
+	page := []visit{}
+	if offset < len(filtered) {
+		page = filtered[offset:min(len(filtered), offset+limit)]
+	}
+	writeJSON(struct {
+		Summary visitSummary `json:"summary"`
+		// Total is the number of visits that match the filters.
+		Total  int     `json:"total"`
+		Visits []visit `json:"visits"`
+	}{summary, len(filtered), page}).ServeHTTP(w, r)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/squat/berlinstrength/api"
+)
+
+// exportRecords writes the members or visits of the given store that are selected by the given options
+// to the file at the given path or, if it is empty, to stdout.
+// From and to are days of the form YYYY-MM-DD and may be empty.
+func exportRecords(kind, path, store, sid, database string, headers map[string]string, from, to string, o api.ExportOptions) error {
+	var export func(context.Context, api.Store, io.Writer, api.ExportOptions) error
+	switch kind {
+	case "members":
+		export = api.ExportMembers
+	case "visits":
+		export = api.ExportVisits
+	default:
+		return fmt.Errorf("%q cannot be exported; expected one of members or visits", kind)
+	}
+	var err error
+	if o.From, err = parseDay(from); err != nil {
+		return err
+	}
+	if o.To, err = parseDay(to); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	s, err := openStore(ctx, store, sid, database, headers)
+	if err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("could not create %q: %v", path, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	return export(ctx, s, w, o)
+}
+
+// parseDay parses the given day of the form YYYY-MM-DD in Berlin; if it is empty, the zero time is returned.
+func parseDay(day string) (time.Time, error) {
+	if day == "" {
+		return time.Time{}, nil
+	}
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := time.ParseInLocation("2006-01-02", day, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date of the form YYYY-MM-DD", day)
+	}
+	return t, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/squat/berlinstrength/api"
+)
+
+// importMembers adds the members in the CSV file at the given path to the given store
+// and prints the problems it finds. It returns an error if any are found.
+// The mapping names the columns of the file that hold each member field.
+func importMembers(path, store, sid, database string, headers, mapping map[string]string, dryRun bool) error {
+	ctx := context.Background()
+	s, err := openStore(ctx, store, sid, database, headers)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open %q: %v", path, err)
+	}
+	defer f.Close()
+	report, err := api.ImportMembers(ctx, s, f, mapping, dryRun)
+	if err != nil {
+		return err
+	}
+	for field, header := range report.Columns {
+		fmt.Printf("%s <- %q\n", field, header)
+	}
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+	if dryRun {
+		fmt.Printf("%d members can be imported\n", report.Valid)
+	} else {
+		fmt.Printf("imported %d members\n", report.Imported)
+	}
+	if len(report.Problems) != 0 {
+		return fmt.Errorf("found %d problems in %q", len(report.Problems), path)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/squat/berlinstrength/api"
 	"github.com/squat/berlinstrength/version"
@@ -19,30 +20,96 @@ import (
 
 func main() {
 	flags := struct {
+		analytics    time.Duration
+		capacity     int
+		checkOut     time.Duration
 		clientSecret string
 		clientID     string
+		database     string
+		dryRun       bool
+		duplicate    time.Duration
 		emails       string
+		exitFile     string
 		file         string
+		format       string
+		from         string
+		grace        int
+		headers      map[string]string
+		journal      string
+		location     string
 		logLevel     string
+		mapping      map[string]string
+		metrics      time.Duration
+		output       string
 		port         int
+		refresh      time.Duration
+		rules        map[string]string
+		soon         int
+		status       string
+		store        string
+		to           string
 		url          string
 		version      bool
 	}{
+		analytics:    5 * time.Minute,
+		capacity:     0,
+		checkOut:     0,
 		clientID:     os.Getenv("GOOGLE_CLIENT_ID"),
 		clientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		database:     "berlinstrength.db",
+		dryRun:       false,
+		duplicate:    0,
 		emails:       "",
+		exitFile:     "",
 		file:         "",
+		format:       "csv",
+		from:         "",
+		grace:        0,
+		headers:      map[string]string{},
+		journal:      "visits.journal",
+		location:     "",
 		logLevel:     "info",
+		mapping:      map[string]string{},
+		metrics:      time.Minute,
+		output:       "",
 		port:         8080,
+		refresh:      time.Minute,
+		rules:        map[string]string{},
+		soon:         7,
+		status:       "",
+		store:        "sheets",
+		to:           "",
 		url:          "http://localhost:8080",
 		version:      false,
 	}
+	flag.DurationVar(&flags.analytics, "analytics-cache", flags.analytics, "time for which the visits read for attendance analytics are cached; 0 disables the cache")
+	flag.IntVar(&flags.capacity, "capacity", flags.capacity, "number of people who may be inside at once; further scans are refused as gym_full; 0 disables the limit; only used with a check-out window")
+	flag.DurationVar(&flags.checkOut, "check-out-window", flags.checkOut, "time after checking in during which people count as inside unless they check out, e.g. 3h; 0 disables occupancy tracking")
 	flag.StringVar(&flags.clientID, "client-id", flags.clientID, "OAuth client secret")
 	flag.StringVar(&flags.clientSecret, "client-secret", flags.clientSecret, "OAuth client secret")
+	flag.StringVar(&flags.database, "database", flags.database, "file path to the local database; only used by the bolt store")
+	flag.BoolVar(&flags.dryRun, "dry-run", flags.dryRun, "only validate the members to import; only used by the import command")
+	flag.DurationVar(&flags.duplicate, "duplicate-window", flags.duplicate, "interval during which repeat scans of the same card do not record visits; 0 records every scan")
 	flag.StringVarP(&flags.emails, "emails", "e", flags.emails, "comma-separated list of allowed emails")
+	flag.StringVar(&flags.exitFile, "exit-file", flags.exitFile, "file path to the RFID scanner at the exit; leave empty to check out by tapping again at the entrance")
 	flag.StringVarP(&flags.file, "file", "f", flags.file, "file path to RFID scanner; leave empty to read from stdin")
+	flag.StringVar(&flags.format, "format", flags.format, "format of exported files; one of: csv, jsonl, xlsx; only used by the export command")
+	flag.StringVar(&flags.from, "from", flags.from, "first day, of the form YYYY-MM-DD, of the expirations or visits to export; only used by the export command")
+	flag.IntVar(&flags.grace, "grace-days", flags.grace, "number of days after a membership expires during which scans are allowed with a warning")
+	flag.StringToStringVar(&flags.headers, "headers", flags.headers, "headers of the member sheet columns, keyed by field; fields are: bsid, expiration, name, email, rfid, photo, plan, credits")
+	flag.StringVarP(&flags.journal, "journal", "j", flags.journal, "file path to the journal of visits that failed to be recorded; visits that can never be recorded are moved to the same path with the suffix .failed; leave empty to only retry from memory")
+	flag.StringVar(&flags.location, "location", flags.location, "name of the location of the RFID scanner, by which check-ins are counted in the metrics")
 	flag.StringVarP(&flags.logLevel, "loglevel", "l", flags.logLevel, "logging verbosity")
+	flag.StringToStringVar(&flags.mapping, "mapping", flags.mapping, "headers of the columns of the file to import, keyed by field; only used by the import command")
+	flag.DurationVar(&flags.metrics, "metrics-refresh", flags.metrics, "interval at which to count the members for the metrics; 0 disables the member metrics")
+	flag.StringVarP(&flags.output, "output", "o", flags.output, "file path to which to export; leave empty to write to stdout; only used by the export command")
 	flag.IntVarP(&flags.port, "port", "p", flags.port, "port on which to listen")
+	flag.DurationVar(&flags.refresh, "roster-refresh", flags.refresh, "interval at which to refresh the cached member rosters; 0 disables the cache")
+	flag.StringToStringVar(&flags.rules, "rules", flags.rules, "effects of the reasons for which a scan may be refused, keyed by reason; reasons are: expired, expiring_soon, grace_period, gym_full, debt, frozen, lost_card, no_credits, passback, retired_card, unknown_card; effects are: allow, deny, warn")
+	flag.IntVar(&flags.soon, "expiring-soon-days", flags.soon, "number of days before a membership expires from which scans warn that it is expiring soon")
+	flag.StringVar(&flags.status, "status", flags.status, "comma-separated list of member statuses to export; statuses are: active, debt, expired, expiring, frozen; only used by the export command")
+	flag.StringVarP(&flags.store, "store", "s", flags.store, "where to store members; one of: sheets, bolt, memory")
+	flag.StringVar(&flags.to, "to", flags.to, "last day, of the form YYYY-MM-DD, of the expirations or visits to export; only used by the export command")
 	flag.StringVarP(&flags.url, "url", "u", flags.url, "redirect URL to use for OAuth")
 	flag.BoolVarP(&flags.version, "version", "v", flags.version, "print version and exit")
 	flag.Parse()
@@ -61,6 +128,48 @@ func main() {
 	}
 	logrus.SetLevel(level)
 
+	if args := flag.Args(); len(args) > 0 && args[0] == "sheet" {
+		if len(args) != 3 || args[1] != "lint" {
+			logrus.Fatalf("Usage: %s sheet lint <sheet ID>", os.Args[0])
+		}
+		if err := lintSheet(args[2], flags.headers); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	if args := flag.Args(); len(args) > 0 && args[0] == "import" {
+		if len(args) < 2 || len(args) > 3 {
+			logrus.Fatalf("Usage: %s import <file> [<sheet ID>]", os.Args[0])
+		}
+		var sid string
+		if len(args) == 3 {
+			sid = args[2]
+		}
+		if err := importMembers(args[1], flags.store, sid, flags.database, flags.headers, flags.mapping, flags.dryRun); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	if args := flag.Args(); len(args) > 0 && args[0] == "export" {
+		if len(args) < 2 || len(args) > 3 {
+			logrus.Fatalf("Usage: %s export members|visits [<sheet ID>]", os.Args[0])
+		}
+		var sid string
+		if len(args) == 3 {
+			sid = args[2]
+		}
+		o := api.ExportOptions{Format: api.ExportFormat(flags.format), SoonDays: flags.soon}
+		if flags.status != "" {
+			o.Statuses = strings.Split(flags.status, ",")
+		}
+		if err := exportRecords(args[1], flags.output, flags.store, sid, flags.database, flags.headers, flags.from, flags.to, o); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
 	if flags.clientID == "" {
 		logrus.Fatalf("The %q flag is required", "--client-id")
 	}
@@ -75,6 +184,13 @@ func main() {
 			logrus.Fatalf("Could not open the RFID scanner located at %q: %v", flags.file, err)
 		}
 	}
+	var exit *os.File
+	if flags.exitFile != "" {
+		exit, err = os.Open(flags.exitFile)
+		if err != nil {
+			logrus.Fatalf("Could not open the exit RFID scanner located at %q: %v", flags.exitFile, err)
+		}
+	}
 	u, err := url.Parse(flags.url)
 	if err != nil {
 		logrus.Fatalf("%q is not a valid URL", "flags.url")
@@ -82,12 +198,42 @@ func main() {
 	if u.Scheme == "" {
 		u.Scheme = "https"
 	}
+	if err := api.ValidateRules(flags.rules); err != nil {
+		logrus.Fatalf("The %q flag is invalid: %v", "--rules", err)
+	}
+	var store api.Store
+	switch flags.store {
+	case "sheets":
+	case "bolt":
+		store, err = api.NewBoltStore(flags.database)
+		if err != nil {
+			logrus.Fatalf("Could not open the database located at %q: %v", flags.database, err)
+		}
+	case "memory":
+		store = api.NewMemoryStore()
+	default:
+		logrus.Fatalf("%q is not a valid store", flags.store)
+	}
 	cfg := api.Config{
-		ClientID:     flags.clientID,
-		ClientSecret: flags.clientSecret,
-		Emails:       strings.Split(flags.emails, ","),
-		File:         f,
-		URL:          &url.URL{Host: u.Host, Scheme: u.Scheme},
+		AnalyticsCache:  flags.analytics,
+		Capacity:        flags.capacity,
+		CheckOutWindow:  flags.checkOut,
+		ClientID:        flags.clientID,
+		ClientSecret:    flags.clientSecret,
+		DuplicateWindow: flags.duplicate,
+		Emails:          strings.Split(flags.emails, ","),
+		ExitFile:        exit,
+		File:            f,
+		GraceDays:       flags.grace,
+		Headers:         flags.headers,
+		Journal:         flags.journal,
+		Location:        flags.location,
+		MetricsRefresh:  flags.metrics,
+		RosterRefresh:   flags.refresh,
+		Rules:           flags.rules,
+		SoonDays:        flags.soon,
+		Store:           store,
+		URL:             &url.URL{Host: u.Host, Scheme: u.Scheme},
 	}
 
 	reg := prometheus.NewRegistry()
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/squat/berlinstrength/api"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/sheets/v4"
+)
+
+// lintSheet checks every row of the membership spreadsheet with the given ID
+// and prints the problems it finds. It returns an error if any are found.
+// Credentials are read from the environment, e.g. GOOGLE_APPLICATION_CREDENTIALS.
+func lintSheet(sid string, headers map[string]string) error {
+	ctx := context.Background()
+	c, err := google.DefaultClient(ctx, sheets.SpreadsheetsReadonlyScope)
+	if err != nil {
+		return fmt.Errorf("failed to find Google credentials: %v", err)
+	}
+	problems, err := api.LintSheet(ctx, c, sid, headers)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("found %d problems in sheet %q", len(problems), sid)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/squat/berlinstrength/api"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/sheets/v4"
+)
+
+// openStore returns the store of the given kind for command line tools.
+// Sheets stores require the ID of the membership spreadsheet; credentials are read from the environment,
+// e.g. GOOGLE_APPLICATION_CREDENTIALS. Bolt stores use the database at the given path.
+func openStore(ctx context.Context, store, sid, database string, headers map[string]string) (api.Store, error) {
+	switch store {
+	case "sheets":
+		if sid == "" {
+			return nil, fmt.Errorf("the sheets store requires the ID of a sheet")
+		}
+		c, err := google.DefaultClient(ctx, sheets.SpreadsheetsScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Google credentials: %v", err)
+		}
+		return api.NewSheetsStore(c, sid, headers)
+	case "bolt":
+		s, err := api.NewBoltStore(database)
+		if err != nil {
+			return nil, fmt.Errorf("could not open the database located at %q: %v", database, err)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("%q is not a store that can be opened from the command line", store)
+}
@@ -6,6 +6,7 @@ import { ThunkAction } from 'redux-thunk';
 import { All } from '../reducers';
 import { Client, expired } from '../reducers/client';
 import { Sheet } from '../reducers/sheets';
+import { Visit, VisitSummary } from '../reducers/visits';
 
 const sound: Howl = new Howl({
     sprite: {
@@ -29,6 +30,7 @@ export enum ActionType {
     ScanSearch = 'ScanSearch',
     ScanSeen = 'ScanSeen',
     SetUser = 'SetUser',
+    SetVisits = 'SetVisits',
     SetWebSocket = 'SetWebSocket',
 }
 
@@ -94,6 +96,14 @@ export interface SetUploadAction extends Action {
     inFlight: boolean;
 }
 
+export interface SetVisitsAction extends Action {
+    error: string;
+    id: string;
+    inFlight: boolean;
+    summary: VisitSummary;
+    visits: Visit[];
+}
+
 export interface SetWebSocketAction extends Action {
     state: boolean;
 }
@@ -362,3 +372,35 @@ export const requestClient = (id: string): AsyncAction<Action> => {
         return Promise.resolve({type: ActionType.Null});
     };
 };
+
+export const setVisits = (id: string, inFlight: boolean, summary: VisitSummary, visits: Visit[], error: string):
+    SetVisitsAction => ({
+    error,
+    id: id.toLowerCase(),
+    inFlight,
+    summary,
+    type: ActionType.SetVisits,
+    visits,
+});
+
+type VisitsResponse = {
+    summary: VisitSummary
+    total: number
+    visits: Visit[]
+};
+
+export const requestVisits = (id: string): AsyncAction<Action> => {
+    return (dispatch: redux.Dispatch<redux.AnyAction>): Promise<Action> => {
+        dispatch(setVisits(id, true, {} as VisitSummary, [], ''));
+        return fetch(document.location.origin + '/api/user/' + id + '/visits?limit=10', {credentials: 'include'})
+            .then((response: Response) => Promise.all([response, response.json()]))
+            .then(([response, json]): Promise<VisitsResponse> => {
+                if (!response.ok) {
+                    throw Error(json.error);
+                }
+                return json;
+            })
+            .then((v: VisitsResponse): Action => dispatch(setVisits(id, false, v.summary, v.visits, '')))
+            .catch((error: Error): Action => dispatch(setVisits(id, false, {} as VisitSummary, [], error.message)));
+    };
+};
@@ -12,6 +12,7 @@ import { ManualScan } from '../reducers/scan';
 import { Fade } from './fade';
 import { Loadable } from './loader';
 import { ErrorScan } from './scan';
+import { ConnectedVisits } from './visits';
 import { TakePhoto } from './webcam';
 
 type Actions = {
@@ -115,83 +116,86 @@ class RegisterForm extends React.Component<RegisterProps & Dispatch, {photo: Blo
 
         return (
             <ErrorSuccess error={r.error} inFlight={r.inFlight} done={this.state.done} close={close}>
-                <form onSubmit={submit}>
-                    <ul className="fields">
-                        <li>
-                            <label>
-                                <span className="field-key field-key--form">Name:</span>
-                                <input
-                                    autoFocus={true}
-                                    className="field-value"
-                                    defaultValue={client ? client.name : ''}
-                                    name="name"
-                                    placeholder="Berlin Strength"
-                                    required={true}
-                                    type="text"
-                                />
-                            </label>
-                        </li>
-                        <li>
-                            <label>
-                                <span className="field-key field-key--form">Email:</span>
-                                <input
-                                    className="field-value"
-                                    defaultValue={client ? client.email : ''}
-                                    name="email"
-                                    placeholder="berlin@strength.de"
-                                    required={true}
-                                    type="email"
-                                />
-                            </label>
-                        </li>
-                        <li>
-                            <label>
-                                <span className="field-key field-key--form">ID:</span>
-                                <input
-                                    className="field-value"
-                                    defaultValue={client ? client.bsID : ''}
-                                    name="id"
-                                    placeholder="100"
-                                    required={true}
-                                    type="text"
-                                    disabled={edit}
-                                />
-                            </label>
-                        </li>
-                        <li>
-                            <label>
-                                <span className="field-key field-key--form">Expiration:</span>
-                                <input
-                                    className="field-value"
-                                    defaultValue={client ? client.expiration.split('T')[0] : ''}
-                                    name="expiration"
-                                    placeholder="01/01/3000"
-                                    required={true}
-                                    type="date"
-                                />
-                            </label>
-                        </li>
-                        <li>
-                            <span className="field-key field-key--form">RFID:</span>
-                            <Loadable
-                             center={true}
-                             inFlight={m.inFlight}
-                             size={30}
-                             style={{display: 'inline-block', position: 'relative'}}
-                            >
-                                <span className="field-value">
-                                    {rfid}{rfid && ' '}<a onClick={rescan}>{rfid ? 'change' : 'scan'}</a>
-                                </span>
-                            </Loadable>
-                        </li>
-                    </ul>
-                    <TakePhoto cb={cb} url={client && client.photo ? `/photo/${client.photo}` : ''} />
-                    <input
-                        type="submit"
-                        style={{display: 'block', margin: 'auto'}}
-                        value={edit ? 'update' : 'register'}
-                    />
-                </form>
+                <div>
+                    <form onSubmit={submit}>
+                        <ul className="fields">
+                            <li>
+                                <label>
+                                    <span className="field-key field-key--form">Name:</span>
+                                    <input
+                                        autoFocus={true}
+                                        className="field-value"
+                                        defaultValue={client ? client.name : ''}
+                                        name="name"
+                                        placeholder="Berlin Strength"
+                                        required={true}
+                                        type="text"
+                                    />
+                                </label>
+                            </li>
+                            <li>
+                                <label>
+                                    <span className="field-key field-key--form">Email:</span>
+                                    <input
+                                        className="field-value"
+                                        defaultValue={client ? client.email : ''}
+                                        name="email"
+                                        placeholder="berlin@strength.de"
+                                        required={true}
+                                        type="email"
+                                    />
+                                </label>
+                            </li>
+                            <li>
+                                <label>
+                                    <span className="field-key field-key--form">ID:</span>
+                                    <input
+                                        className="field-value"
+                                        defaultValue={client ? client.bsID : ''}
+                                        name="id"
+                                        placeholder="100"
+                                        required={true}
+                                        type="text"
+                                        disabled={edit}
+                                    />
+                                </label>
+                            </li>
+                            <li>
+                                <label>
+                                    <span className="field-key field-key--form">Expiration:</span>
+                                    <input
+                                        className="field-value"
+                                        defaultValue={client ? client.expiration.split('T')[0] : ''}
+                                        name="expiration"
+                                        placeholder="01/01/3000"
+                                        required={true}
+                                        type="date"
+                                    />
+                                </label>
+                            </li>
+                            <li>
+                                <span className="field-key field-key--form">RFID:</span>
+                                <Loadable
+                                 center={true}
+                                 inFlight={m.inFlight}
+                                 size={30}
+                                 style={{display: 'inline-block', position: 'relative'}}
+                                >
+                                    <span className="field-value">
+                                        {rfid}{rfid && ' '}<a onClick={rescan}>{rfid ? 'change' : 'scan'}</a>
+                                    </span>
+                                </Loadable>
+                            </li>
+                        </ul>
+                        <TakePhoto cb={cb} url={client && client.photo ? `/photo/${client.photo}` : ''} />
+                        <input
+                            type="submit"
+                            style={{display: 'block', margin: 'auto'}}
+                            value={edit ? 'update' : 'register'}
+                        />
+                    </form>
+                    {edit && client && <ConnectedVisits bsID={client.bsID}/>}
+                </div>
             </ErrorSuccess>
         );
     }
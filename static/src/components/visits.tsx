@@ -0,0 +1,97 @@
+import * as React from 'react';
+import { connect } from 'react-redux';
+import * as redux from 'redux';
+
+import { requestVisits } from '../actions';
+import { All } from '../reducers';
+import { Visit, VisitHistory } from '../reducers/visits';
+import { Loadable } from './loader';
+
+type VisitsProps = {
+    bsID: string
+};
+
+type ConnectedState = {
+    history?: VisitHistory
+};
+
+type Actions = {
+    requestVisits: (id: string) => Promise<redux.AnyAction>
+};
+
+type Dispatch = {
+    actions: Actions
+};
+
+const formatDate = (t: string): string => (
+    new Date(t).toLocaleDateString('en-GB', {month: '2-digit', day: '2-digit', year: 'numeric'})
+);
+
+const formatTime = (t: string): string => (
+    new Date(t).toLocaleString('en-GB', {
+        day: '2-digit',
+        hour: '2-digit',
+        minute: '2-digit',
+        month: '2-digit',
+        year: 'numeric',
+    })
+);
+
+class VisitList extends React.Component<VisitsProps & ConnectedState & Dispatch> {
+    public render() {
+        const h = this.props.history;
+        if (!h || h.inFlight) {
+            return <Loadable inFlight={true} center={true} size={30} style={{minHeight: 40, position: 'relative'}}/>;
+        }
+        if (h.error) {
+            return (
+                <ul className="fields">
+                    <li>
+                        <span className="field-key field-key--error">Visits:</span>
+                        <span className="field-value">{h.error}</span>
+                    </li>
+                </ul>
+            );
+        }
+        const s = h.summary;
+        return (
+            <div>
+                <ul className="fields">
+                    <li>
+                        <span className="field-key">Visits:</span>
+                        <span className="field-value">{s.total}</span>
+                    </li>
+                    <li>
+                        <span className="field-key">This month:</span>
+                        <span className="field-value">{s.thisMonth}</span>
+                    </li>
+                    <li>
+                        <span className="field-key">Per week:</span>
+                        <span className="field-value">{s.perWeek.toFixed(1)}</span>
+                    </li>
+                    <li>
+                        <span className="field-key">Last visit:</span>
+                        <span className="field-value">{s.lastVisit ? formatDate(s.lastVisit) : 'never'}</span>
+                    </li>
+                </ul>
+                <ul className="visits">
+                    {h.visits.map((v: Visit) => <li key={v.time}>{formatTime(v.time)}</li>)}
+                </ul>
+            </div>
+        );
+    }
+
+    public componentDidMount() {
+        this.props.actions.requestVisits(this.props.bsID);
+    }
+}
+
+const mapStateToProps = (state: All, props: VisitsProps): ConnectedState => ({
+    history: state.visits.get(props.bsID.toLowerCase()),
+});
+
+const mapDispatchToProps = (dispatch: redux.Dispatch<redux.AnyAction>) => (
+    {actions: redux.bindActionCreators({requestVisits}, dispatch)}
+);
+
+export const ConnectedVisits = connect(mapStateToProps, mapDispatchToProps)(VisitList);
@@ -6,6 +6,7 @@ import { ManualScan, manualScan, scan, ScanState } from './scan';
 import { Server, server } from './server';
 import { SetSheet, setSheet, Sheet, sheets } from './sheets';
 import { User, user } from './user';
+import { visits, VisitState } from './visits';
 
 export type All = {
     clients: ClientState
@@ -18,6 +19,7 @@ export type All = {
     sheets: Sheet[]
     upload: Upload
     user: User
+    visits: VisitState
 };
 
 export const reducers = combineReducers<All>({
@@ -31,4 +33,5 @@ export const reducers = combineReducers<All>({
     sheets,
     upload,
     user,
+    visits,
 });
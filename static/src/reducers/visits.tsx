@@ -0,0 +1,39 @@
+import Action, { ActionType, SetVisitsAction } from '../actions';
+
+export type Visit = {
+    bsID: string
+    pass?: number
+    time: string
+};
+
+export type VisitSummary = {
+    lastVisit?: string
+    perWeek: number
+    thisMonth: number
+    total: number
+};
+
+export type VisitHistory = {
+    error: string
+    inFlight: boolean
+    summary: VisitSummary
+    visits: Visit[]
+};
+
+export type VisitState = Map<string, VisitHistory>;
+
+const initialVisitState: VisitState = new Map<string, VisitHistory>();
+
+export const visits = (state: VisitState = initialVisitState, action: Action): VisitState => {
+    switch (action.type) {
+        case ActionType.SetVisits:
+            const h: VisitHistory = {
+                error: (action as SetVisitsAction).error,
+                inFlight: (action as SetVisitsAction).inFlight,
+                summary: (action as SetVisitsAction).summary,
+                visits: (action as SetVisitsAction).visits,
+            };
+            return new Map(state).set((action as SetVisitsAction).id, h);
+    }
+    return state;
+};